@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	db "my-social-network/db/sqlite"
+	util "my-social-network/util"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Periodically deletes accounts whose grace period is over
+func sweepDeletedAccounts() {
+	ticker := time.NewTicker(accountDeletionSweepInterval)
+	defer ticker.Stop()
+	for {
+		ids, err := db.GetUsersDueForDeletion(util.GetCurrentMilli())
+		if err != nil {
+			fmt.Println("Error: could not get accounts due for deletion. ", err)
+		}
+		for _, id := range ids {
+			err = deleteAccount(id)
+			if err != nil {
+				fmt.Printf("Error: could not delete account %v. %v\n", id, err)
+			} else {
+				fmt.Println("Account deleted: ", id)
+			}
+		}
+		<-ticker.C
+	}
+}
+
+// Deletes user data in one transaction, then the images and exports that belonged to it
+func deleteAccount(userId int) error {
+	jobs, err := db.GetJobsByUserId(userId, JOB_TYPE_EXPORT)
+	if err != nil {
+		return err
+	}
+
+	images, err := db.DeleteUserData(userId)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range *jobs {
+		removeExportFile(job.File)
+	}
+
+	removeUserClients(userId)
+
+	for _, image := range images {
+		err = os.Remove(filepath.Join(IMAGES_DIRECTORY, filepath.Base(image)))
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println("Error: could not delete image. ", err)
+		}
+	}
+	return nil
+}
@@ -4,52 +4,523 @@ import (
 	"fmt"
 	db "my-social-network/db/sqlite"
 	types "my-social-network/types"
+	util "my-social-network/util"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
 func getUserFromRequest(r *http.Request) (*types.User, *types.Error) {
-	keys, ok := r.URL.Query()["session_id"]
-	if !ok || len(keys[0]) < 1 {
-		return nil, &types.Error{Type: MISSING_PARAM, Message: "Error: missing request parameter: session_id"}
+	user, _, err := getUserAndSessionFromRequest(r)
+	return user, err
+}
+
+func getUserAndSessionFromRequest(r *http.Request) (*types.User, *types.UserSession, *types.Error) {
+	return getUserAndSessionBySessionId(getSessionIdFromRequest(r, false))
+}
+
+// Reads session token from "Authorization: Bearer <token>" header or from session cookie.
+// session_id query parameter is only accepted when allowQueryParam is set (websocket upgrade)
+func getSessionIdFromRequest(r *http.Request, allowQueryParam bool) string {
+	authorization := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
 	}
-	session_id := keys[0]
 
-	user, err := db.GetUserBySessionId(session_id)
+	cookie, err := r.Cookie(SESSION_COOKIE_NAME)
+	if err == nil && strings.TrimSpace(cookie.Value) != "" {
+		return strings.TrimSpace(cookie.Value)
+	}
 
+	if allowQueryParam {
+		keys, ok := r.URL.Query()["session_id"]
+		if ok && len(keys[0]) > 0 {
+			return keys[0]
+		}
+	}
+	return ""
+}
+
+// Accepts session or personal API token. Tokens have to include scope
+// "<resource>:read" for GET requests and "<resource>:write" for the rest
+func getUserFromRequestWithScope(r *http.Request, resource string) (*types.User, *types.Error) {
+	token := getSessionIdFromRequest(r, false)
+	if strings.HasPrefix(token, API_TOKEN_PREFIX) {
+		return getUserByApiToken(token, requiredScope(r, resource))
+	}
+	return getUserFromRequest(r)
+}
+
+func requiredScope(r *http.Request, resource string) string {
+	if r.Method == "GET" || r.Method == "HEAD" || r.Method == "OPTIONS" {
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+func getUserByApiToken(tokenStr string, scope string) (*types.User, *types.Error) {
+	token, err := db.GetApiTokenByHash(util.HashToken(tokenStr))
 	if err != nil {
-		return nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get api token from database"}
+	}
+
+	now := util.GetCurrentMilli()
+	if token == nil || token.ExpiresAt <= now {
+		return nil, &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired api token"}
+	}
+
+	hasScope := false
+	for _, s := range token.Scopes {
+		if s == scope {
+			hasScope = true
+			break
+		}
+	}
+	if !hasScope {
+		return nil, &types.Error{Type: INSUFFICIENT_SCOPE, Message: fmt.Sprintf("Error: api token requires scope %v", scope)}
+	}
+
+	if now-token.LastUsedAt > API_TOKEN_TOUCH_INTERVAL_MILLI {
+		err = db.TouchApiToken(token.Id, now)
+		if err != nil {
+			return nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not update api token in database"}
+		}
 	}
 
+	user, err := db.GetUserById(token.UserId)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+	}
 	if user == nil {
 		return nil, &types.Error{Type: NO_USER_FOUND, Message: "Could not find user"}
 	}
-
 	return user, nil
 }
 
+// Returns valid scopes ("posts:read", ...) or nil if one of them is unknown
+func parseScopes(value string) []string {
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range strings.FieldsFunc(value, func(c rune) bool { return c == ',' || c == ' ' }) {
+		valid := false
+		for _, resource := range apiTokenResources {
+			if scope == resource+":read" || scope == resource+":write" {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func getUserAndSessionBySessionId(session_id string) (*types.User, *types.UserSession, *types.Error) {
+	if session_id == "" {
+		return nil, nil, &types.Error{Type: MISSING_PARAM, Message: "Error: missing session token"}
+	}
+
+	//Account and security settings can only be changed by signed in user
+	if strings.HasPrefix(session_id, API_TOKEN_PREFIX) {
+		return nil, nil, &types.Error{Type: AUTHORIZATION, Message: "Error: api tokens are not accepted by this endpoint"}
+	}
+
+	session, err := db.GetSessionBySessionId(session_id)
+	if err != nil {
+		return nil, nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get session from database"}
+	}
+
+	if session == nil {
+		return nil, nil, &types.Error{Type: NO_USER_FOUND, Message: "Could not find user"}
+	}
+
+	now := util.GetCurrentMilli()
+
+	if session.Revoked || session.ExpiresAt <= now {
+		return nil, nil, &types.Error{Type: SESSION_REVOKED, Message: "Error: session has been revoked or has expired"}
+	}
+
+	//Sliding renewal
+	if now-session.LastSeen > SESSION_TOUCH_INTERVAL_MILLI {
+		expiresAt := sessionExpiresAt(session.CreatedAt, now)
+		err = db.TouchSession(session.Id, now, expiresAt)
+		if err != nil {
+			return nil, nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not update session in database"}
+		}
+		session.LastSeen = now
+		session.ExpiresAt = expiresAt
+	}
+
+	user, err := db.GetUserById(session.UserId)
+
+	if err != nil {
+		return nil, nil, &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+	}
+
+	if user == nil {
+		return nil, nil, &types.Error{Type: NO_USER_FOUND, Message: "Could not find user"}
+	}
+
+	return user, session, nil
+}
+
+// Creates and saves a new session for the user signing in from request r
+func createSession(userId int, r *http.Request) (*types.UserSession, error) {
+	now := util.GetCurrentMilli()
+	session := types.UserSession{
+		SessionId: generateSessionId(),
+		UserId:    userId,
+		CreatedAt: now,
+		LastSeen:  now,
+		ExpiresAt: sessionExpiresAt(now, now),
+		UserAgent: r.UserAgent(),
+		Ip:        getClientIp(r),
+	}
+	err := db.SaveSession(session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Revokes sessions of the user and closes their websocket connections.
+// Session exceptSessionId is kept (pass empty string to revoke all)
+func revokeUserSessions(userId int, exceptSessionId string) (*int64, error) {
+	sessions, err := db.GetActiveSessionsByUserId(userId, util.GetCurrentMilli())
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := db.RevokeSessionsByUserId(userId, exceptSessionId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, session := range *sessions {
+		if session.SessionId != exceptSessionId {
+			removeSessionClient(userId, session.SessionId)
+		}
+	}
+	return num, nil
+}
+
+func setSessionCookie(w http.ResponseWriter, session *types.UserSession) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    session.SessionId,
+		Path:     "/",
+		MaxAge:   int(sessionAbsoluteTimeout.Seconds()),
+		HttpOnly: true,
+		Secure:   sessionCookieSecure,
+		SameSite: sessionCookieSameSite,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SESSION_COOKIE_NAME,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   sessionCookieSecure,
+		SameSite: sessionCookieSameSite,
+	})
+}
+
+// Session expires after idle timeout since last activity, but never later than absolute timeout since creation
+func sessionExpiresAt(createdAt int64, lastSeen int64) int64 {
+	absolute := createdAt + sessionAbsoluteTimeout.Milliseconds()
+	idle := lastSeen + sessionIdleTimeout.Milliseconds()
+	if idle < absolute {
+		return idle
+	}
+	return absolute
+}
+
+// Periodically removes expired and revoked sessions from database
+func sweepSessions() {
+	ticker := time.NewTicker(sessionSweepInterval)
+	defer ticker.Stop()
+	for {
+		num, err := db.DeleteExpiredSessions(util.GetCurrentMilli())
+		if err != nil {
+			fmt.Println("Error: could not delete expired sessions. ", err)
+		} else if *num > 0 {
+			fmt.Println("Expired sessions deleted: ", *num)
+		}
+
+		err = db.DeleteExpiredTwoFactorChallenges(util.GetCurrentMilli())
+		if err != nil {
+			fmt.Println("Error: could not delete expired two factor challenges. ", err)
+		}
+
+		err = db.DeleteExpiredOidcStates(util.GetCurrentMilli())
+		if err != nil {
+			fmt.Println("Error: could not delete expired oidc states. ", err)
+		}
+
+		//Attempts outside of the window do not count anymore
+		_, err = db.DeleteLoginAttemptsBefore(util.GetCurrentMilli() - loginAttemptWindow.Milliseconds())
+		if err != nil {
+			fmt.Println("Error: could not delete old login attempts. ", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Returns ACCOUNT_LOCKED error if signin to the account (userId 0 if unknown) or from the ip
+// is throttled after failed attempts
+func checkLoginThrottle(userId int, ip string) *types.Error {
+	now := util.GetCurrentMilli()
+	since := now - loginAttemptWindow.Milliseconds()
+
+	failures, last, err := db.GetLoginFailuresByIp(ip, since)
+	if err != nil {
+		return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get login attempts from database. %v", err)}
+	}
+	retryAt := loginRetryAt(failures, last, ipBackoffAfter, ipLockoutAfter)
+
+	if userId > 0 {
+		failures, last, err = db.GetLoginFailuresByUserId(userId, since)
+		if err != nil {
+			return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get login attempts from database. %v", err)}
+		}
+		accountRetryAt := loginRetryAt(failures, last, accountBackoffAfter, accountLockoutAfter)
+		if accountRetryAt > retryAt {
+			retryAt = accountRetryAt
+		}
+	}
+
+	if retryAt > now {
+		seconds := (retryAt - now + 999) / 1000
+		return &types.Error{Type: ACCOUNT_LOCKED, Message: fmt.Sprintf("Error: too many failed signin attempts. Try again in %v seconds", seconds)}
+	}
+	return nil
+}
+
+// Time until which signin is blocked after failures. Delay doubles on every failure
+// past backoffAfter and turns into lockout once lockoutAfter is reached
+func loginRetryAt(failures int, lastFailure int64, backoffAfter int, lockoutAfter int) int64 {
+	if failures >= lockoutAfter {
+		return lastFailure + loginLockoutDuration.Milliseconds()
+	}
+	if failures < backoffAfter {
+		return 0
+	}
+	delay := loginBackoffBase
+	for i := backoffAfter; i < failures && delay < loginLockoutDuration; i++ {
+		delay *= 2
+	}
+	if delay > loginLockoutDuration {
+		delay = loginLockoutDuration
+	}
+	return lastFailure + delay.Milliseconds()
+}
+
+// Records failed signin. Known accounts also get security events
+func recordLoginFailure(account *types.User, r *http.Request) {
+	now := util.GetCurrentMilli()
+	ip := getClientIp(r)
+
+	userId := 0
+	if account != nil {
+		userId = account.Id
+	}
+
+	err := db.SaveLoginAttempt(userId, ip, false, now)
+	if err != nil {
+		fmt.Println("Error: could not save login attempt. ", err)
+	}
+
+	if account == nil {
+		return
+	}
+
+	event := types.SecurityEvent{UserId: account.Id, Type: SECURITY_EVENT_LOGIN_FAILED, Ip: ip, UserAgent: r.UserAgent(), Date: now}
+	err = db.SaveSecurityEvent(event)
+	if err != nil {
+		fmt.Println("Error: could not save security event. ", err)
+	}
+
+	failures, _, err := db.GetLoginFailuresByUserId(account.Id, now-loginAttemptWindow.Milliseconds())
+	if err != nil {
+		fmt.Println("Error: could not get login attempts. ", err)
+		return
+	}
+	if failures == accountLockoutAfter {
+		event.Type = SECURITY_EVENT_ACCOUNT_LOCKED
+		err = db.SaveSecurityEvent(event)
+		if err != nil {
+			fmt.Println("Error: could not save security event. ", err)
+		}
+	}
+}
+
+// Successful signin resets failure counter of the account and cancels its scheduled deletion
+func recordLoginSuccess(userId int, r *http.Request) {
+	now := util.GetCurrentMilli()
+	err := db.SaveLoginAttempt(userId, getClientIp(r), true, now)
+	if err != nil {
+		fmt.Println("Error: could not save login attempt. ", err)
+	}
+
+	num, err := db.CancelUserDeletion(userId)
+	if err != nil {
+		fmt.Println("Error: could not cancel account deletion. ", err)
+	} else if *num > 0 {
+		event := types.SecurityEvent{UserId: userId, Type: SECURITY_EVENT_DELETION_CANCELLED, Ip: getClientIp(r), UserAgent: r.UserAgent(), Date: now}
+		err = db.SaveSecurityEvent(event)
+		if err != nil {
+			fmt.Println("Error: could not save security event. ", err)
+		}
+	}
+}
+
+// Address of the connection or, if it comes from a trusted proxy, the nearest address in
+// X-Forwarded-For that is not a trusted proxy. Clients can put anything in the header
+func getClientIp(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
 func isAccessRestricted(userId int, personId int) *types.Error {
 
 	person, err := db.GetUserById(personId)
 	if err != nil {
 		return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get user from database: %v", personId)}
 	}
+	if person == nil {
+		return &types.Error{Type: NO_USER_FOUND, Message: "Error: user not found"}
+	}
+
+	//Blocked users see nothing of the blocker
+	if userId != personId {
+		blocked, err := db.HasBlocked(personId, userId)
+		if err != nil {
+			return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get blocks from database for user: %v", personId)}
+		}
+		if blocked {
+			return &types.Error{Type: USER_BLOCKED, Message: "Error: access is restricted"}
+		}
+	}
 	privacy := person.Privacy
 	if privacy == "private" {
-		followings, err := db.GetFollowing(userId)
+		isFollowing, err := db.IsApprovedFollower(userId, personId)
 		if err != nil {
 			return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get followings from database for user: %v", personId)}
 		}
-		isFollowing := false
-		for i := 0; i < len(*followings); i++ {
-			following := (*followings)[i]
-			if personId == following.Following.Id && following.Approved {
-				isFollowing = true
-				break
-			}
-		}
-		if !isFollowing {
+		if !isFollowing && userId != personId {
 			return &types.Error{Type: AUTHORIZATION, Message: "Private accont. Access is restricted"}
 		}
 	}
 	return nil
 }
+
+// Error if either user has blocked the other. Follows, messages and invites are not allowed then
+func checkNotBlocked(userId int, personId int) *types.Error {
+	blocked, err := db.IsBlockedBetween(userId, personId)
+	if err != nil {
+		return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get blocks from database for user: %v", personId)}
+	}
+	if blocked {
+		return &types.Error{Type: USER_BLOCKED, Message: "Error: not allowed between blocked users"}
+	}
+	return nil
+}
+
+// Blanks email, date of birth and about me the viewer is not allowed to see.
+// Visibility settings are shown to the owner only
+func applyFieldVisibility(viewerId int, person *types.User) *types.Error {
+	if viewerId == person.Id {
+		return nil
+	}
+
+	isFollower, err := db.IsApprovedFollower(viewerId, person.Id)
+	if err != nil {
+		return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get followers from database for user: %v", person.Id)}
+	}
+
+	visible := func(visibility string) bool {
+		return visibility == VISIBILITY_PUBLIC || (visibility == VISIBILITY_FOLLOWERS && isFollower)
+	}
+
+	if !visible(person.EmailVisibility) {
+		person.Email = ""
+	}
+	if !visible(person.DateOfBirthVisibility) {
+		person.DateOfBirth = 0
+	}
+	if !visible(person.AboutMeVisibility) {
+		person.AboutMe = ""
+	}
+	person.EmailVisibility = ""
+	person.DateOfBirthVisibility = ""
+	person.AboutMeVisibility = ""
+	return nil
+}
+
+// Creates verification token for user's current email and mails the link
+func sendVerificationEmail(user *types.User) error {
+	token, err := generateSecureToken()
+	if err != nil {
+		return err
+	}
+
+	now := util.GetCurrentMilli()
+	_, err = db.SaveEmailVerificationToken(user.Id, user.Email, util.HashToken(token), now, now+emailVerificationTokenTTL.Milliseconds())
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Please confirm your email address.\n\n"+
+		"Follow the link to verify it: %v/verify-email?token=%v\n\n"+
+		"The link is valid for %v.", appUrl, token, emailVerificationTokenTTL)
+	sendMail(user.Email, "Verify your email", body)
+	return nil
+}
+
+// Posting, messaging and creating groups require confirmed email
+func requireVerifiedEmail(user *types.User) *types.Error {
+	if !user.EmailVerified {
+		return &types.Error{Type: EMAIL_NOT_VERIFIED, Message: "Error: email address has to be verified first"}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Settings below can be overridden with environment variables.
+// Durations use Go syntax, e.g. "720h", "30m"
+
+// Session is invalid after this time no matter how active it is
+var sessionAbsoluteTimeout = getEnvDuration("SESSION_ABSOLUTE_TIMEOUT", 30*24*time.Hour)
+
+// Session is invalid if it was not used for this time
+var sessionIdleTimeout = getEnvDuration("SESSION_IDLE_TIMEOUT", 7*24*time.Hour)
+
+// How often expired and revoked sessions are purged from database
+var sessionSweepInterval = getEnvDuration("SESSION_SWEEP_INTERVAL", time.Hour)
+
+// Accept session_id query parameter on websocket upgrade (browsers cannot set headers there)
+var wsQuerySessionIdEnabled = getEnvBool("WS_QUERY_SESSION_ID", true)
+
+// Session cookie attributes. Secure cookies are only sent over https (and to localhost)
+var sessionCookieSecure = getEnvBool("SESSION_COOKIE_SECURE", true)
+var sessionCookieSameSite = getEnvSameSite("SESSION_COOKIE_SAMESITE", http.SameSiteLaxMode)
+
+// Outbound mail. MAIL_DRIVER is "spool" (write mail to MAIL_SPOOL_DIR) or "smtp"
+var mailDriver = getEnvString("MAIL_DRIVER", "spool")
+var mailSpoolDirectory = getEnvString("MAIL_SPOOL_DIR", "mail_spool")
+var mailFrom = getEnvString("MAIL_FROM", "no-reply@localhost")
+var smtpHost = getEnvString("SMTP_HOST", "localhost")
+var smtpPort = getEnvString("SMTP_PORT", "25")
+var smtpUsername = getEnvString("SMTP_USERNAME", "")
+var smtpPassword = getEnvString("SMTP_PASSWORD", "")
+
+// Links in emails point to the web client
+var appUrl = getEnvString("APP_URL", clientOrigin)
+
+var passwordResetTokenTTL = getEnvDuration("PASSWORD_RESET_TOKEN_TTL", time.Hour)
+var emailVerificationTokenTTL = getEnvDuration("EMAIL_VERIFICATION_TOKEN_TTL", 48*time.Hour)
+
+// Signin throttling. Failed attempts are counted within LOGIN_ATTEMPT_WINDOW.
+// After *_BACKOFF_AFTER failures every further attempt has to wait LOGIN_BACKOFF_BASE,
+// doubled on each failure. After *_LOCKOUT_AFTER failures signin is locked for LOGIN_LOCKOUT_DURATION
+var loginAttemptWindow = getEnvDuration("LOGIN_ATTEMPT_WINDOW", time.Hour)
+var loginBackoffBase = getEnvDuration("LOGIN_BACKOFF_BASE", time.Second)
+var loginLockoutDuration = getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+var accountBackoffAfter = getEnvInt("ACCOUNT_BACKOFF_AFTER", 3)
+var accountLockoutAfter = getEnvInt("ACCOUNT_LOCKOUT_AFTER", 10)
+var ipBackoffAfter = getEnvInt("IP_BACKOFF_AFTER", 10)
+var ipLockoutAfter = getEnvInt("IP_LOCKOUT_AFTER", 50)
+
+// Two-factor authentication. Issuer is the account name shown in authenticator apps
+var totpIssuer = getEnvString("TOTP_ISSUER", "my-social-network")
+var twoFactorChallengeTTL = getEnvDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute)
+
+// Public url of this api, used in redirect urls of external sign in
+var serverUrl = getEnvString("SERVER_URL", "http://localhost:8080")
+
+// External sign in (OpenID Connect). OIDC_PROVIDERS is a comma separated list of names,
+// each configured with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET.
+// OIDC_FAKE_IDP adds provider "fake" served by this process (development only)
+var oidcProviderNames = getEnvString("OIDC_PROVIDERS", "")
+var oidcFakeIdpEnabled = getEnvBool("OIDC_FAKE_IDP", false)
+var oidcStateTTL = getEnvDuration("OIDC_STATE_TTL", 10*time.Minute)
+
+// Personal API tokens expire after API_TOKEN_DEFAULT_TTL unless expires_in_days is given
+var apiTokenDefaultTTL = getEnvDuration("API_TOKEN_DEFAULT_TTL", 90*24*time.Hour)
+var apiTokenMaxTTL = getEnvDuration("API_TOKEN_MAX_TTL", 365*24*time.Hour)
+
+// Deleted accounts are kept for ACCOUNT_DELETION_GRACE_PERIOD. Signing in cancels deletion
+var accountDeletionGracePeriod = getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 14*24*time.Hour)
+var accountDeletionSweepInterval = getEnvDuration("ACCOUNT_DELETION_SWEEP_INTERVAL", time.Hour)
+
+// Personal data export archives can be downloaded for EXPORT_TTL
+var exportTTL = getEnvDuration("EXPORT_TTL", 24*time.Hour)
+var exportSweepInterval = getEnvDuration("EXPORT_SWEEP_INTERVAL", time.Hour)
+
+// Largest accepted image upload in bytes
+var imageMaxBytes = getEnvInt("IMAGE_MAX_BYTES", 5*1024*1024)
+
+// Comments are at most COMMENT_MAX_LENGTH characters. Replies nest up to COMMENT_MAX_DEPTH levels below top level comments
+var commentMaxLength = getEnvInt("COMMENT_MAX_LENGTH", 250)
+var commentMaxDepth = getEnvInt("COMMENT_MAX_DEPTH", 3)
+
+// Trending hashtags are counted over the last TRENDING_WINDOW unless the request gives hours
+var trendingWindow = getEnvDuration("TRENDING_WINDOW", 24*time.Hour)
+
+// Comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For header is trusted.
+// Without it the client ip is the address of the connection
+var trustedProxies = getEnvNetworks("TRUSTED_PROXIES")
+
+// Comma separated list of reactions allowed on posts and comments
+var reactionTypes = getEnvList("REACTION_TYPES", []string{"like", "love", "haha", "wow", "sad", "angry"})
+
+func getEnvString(key string, defaultValue string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		fmt.Printf("Invalid value of %v: %v. Using default: %v\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil || i <= 0 {
+		fmt.Printf("Invalid value of %v: %v. Using default: %v\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return i
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("Invalid value of %v: %v. Using default: %v\n", key, value, defaultValue)
+		return defaultValue
+	}
+	return b
+}
+
+// Comma separated values, lower cased. Empty items are skipped
+func getEnvList(key string, defaultValue []string) []string {
+	values := []string{}
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.ToLower(strings.TrimSpace(value))
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return defaultValue
+	}
+	return values
+}
+
+// Comma separated IPs or CIDRs. Invalid items are skipped
+func getEnvNetworks(key string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, value := range getEnvList(key, []string{}) {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			fmt.Printf("Invalid value in %v: %v. Skipping it\n", key, value)
+			continue
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func getEnvSameSite(key string, defaultValue http.SameSite) http.SameSite {
+	value := strings.ToLower(strings.TrimSpace(os.Getenv(key)))
+	switch value {
+	case "":
+		return defaultValue
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	fmt.Printf("Invalid value of %v: %v. Using default\n", key, value)
+	return defaultValue
+}
@@ -3,6 +3,11 @@ package main
 const clientOrigin = "http://localhost:3000"
 
 const IMAGES_DIRECTORY = "images"
+const EXPORTS_DIRECTORY = "exports"
+
+// Sessions. last_seen is not written on every request, only once per interval
+const SESSION_TOUCH_INTERVAL_MILLI = 60 * 1000
+const SESSION_COOKIE_NAME = "session_id"
 
 const WRONG_METHOD = "error_wrong_method"
 const NO_USER_FOUND = "no_user_found"
@@ -17,16 +22,145 @@ const INVALID_EMAIL = "invalid email"
 const INVALID_PASSWORD = "invalid password"
 const INVALID_ABOUT_ME = "invalid about me"
 const IMAGE_UPLOAD_ERROR = "image upload error"
+const IMAGE_TOO_LARGE = "image too large"
+const INVALID_IMAGE_FORMAT = "invalid image format"
 
 const DATABASE_ERROR = "database error"
 const MISSING_PARAM = "missing parameter"
 const AUTHORIZATION = "authorization"
+const SESSION_NOT_FOUND = "session not found"
+const SESSION_REVOKED = "session revoked"
+const INVALID_TOKEN = "invalid token"
+const EMAIL_NOT_VERIFIED = "email not verified"
+const EMAIL_ALREADY_VERIFIED = "email already verified"
+const ACCOUNT_LOCKED = "account locked"
+const TWO_FACTOR_NOT_ENABLED = "two factor not enabled"
+const TWO_FACTOR_ALREADY_ENABLED = "two factor already enabled"
+const TWO_FACTOR_NOT_ENROLLED = "two factor not enrolled"
+const INVALID_TWO_FACTOR_CODE = "invalid two factor code"
+const OIDC_PROVIDER_NOT_FOUND = "oidc provider not found"
+const OIDC_ERROR = "oidc error"
+const IDENTITY_ALREADY_LINKED = "identity already linked"
+const IDENTITY_NOT_FOUND = "identity not found"
+const ACCOUNT_EXISTS = "account exists"
+const LAST_SIGNIN_METHOD = "last signin method"
+const INVALID_SCOPE = "invalid scope"
+const INSUFFICIENT_SCOPE = "insufficient scope"
+const INVALID_TOKEN_NAME = "invalid token name"
+const API_TOKEN_NOT_FOUND = "api token not found"
+const ACCOUNT_DELETION_SCHEDULED = "account deletion scheduled"
+const JOB_NOT_FOUND = "job not found"
+const EXPORT_NOT_READY = "export not ready"
+const INVALID_FIELD = "invalid field"
+const INVALID_PRIVACY = "invalid privacy"
+const INVALID_VISIBILITY = "invalid visibility"
+const INVALID_CURSOR = "invalid cursor"
+const INVALID_SORT = "invalid sort"
+const USER_BLOCKED = "user blocked"
+const INVALID_AUDIENCE_LIST_NAME = "invalid audience list name"
+const AUDIENCE_LIST_NOT_FOUND = "audience list not found"
+const POST_NOT_FOUND = "post not found"
+const COMMENT_NOT_FOUND = "comment not found"
+const COMMENT_TOO_DEEP = "comment too deep"
+const INVALID_REACTION = "invalid reaction"
+const INVALID_HASHTAG = "invalid hashtag"
+
+// Cursor pagination
+const PAGE_SIZE_DEFAULT = 20
+const PAGE_SIZE_MAX = 100
+
+// Reaction targets
+const REACTION_TARGET_POST = "post"
+const REACTION_TARGET_COMMENT = "comment"
+
+// Sources of mentions and hashtags
+const CONTENT_SOURCE_POST = "post"
+const CONTENT_SOURCE_COMMENT = "comment"
+const CONTENT_SOURCE_MESSAGE = "message"
+const MENTIONS_MAX = 20
+const HASHTAG_MAX_LENGTH = 50
+const TRENDING_HASHTAGS_DEFAULT_LIMIT = 10
+
+// Number of newest comments sent with each feed post
+const FEED_COMMENT_PREVIEW_SIZE = 3
+
+// Sort orders of /users
+const USER_SORT_NAME = "name"
+const USER_SORT_NEWEST = "newest"
+
+// Follow suggestions. Score is the weighted sum of shared connections
+const SUGGESTIONS_DEFAULT_LIMIT = 10
+const SUGGESTION_WEIGHT_MUTUAL_FOLLOW = 3
+const SUGGESTION_WEIGHT_GROUP = 2
+const SUGGESTION_WEIGHT_EVENT = 1
+const SUGGESTION_WEIGHT_CHAT_GROUP = 1
+
+// Post privacy. "specific" posts are shared with the users in post_audience,
+// "audience" posts with members of the author's audience lists
+const POST_PRIVACY_PUBLIC = "public"
+const POST_PRIVACY_PRIVATE = "private"
+const POST_PRIVACY_SPECIFIC = "specific"
+const POST_PRIVACY_AUDIENCE = "audience"
+const AUDIENCE_LIST_NAME_MAX_LENGTH = 50
+
+// Profile field visibility (email, date of birth, about me)
+const VISIBILITY_PUBLIC = "public"
+const VISIBILITY_FOLLOWERS = "followers"
+const VISIBILITY_PRIVATE = "private"
+
+// Two-factor authentication
+const TOTP_SKEW_STEPS = 1
+const RECOVERY_CODES_COUNT = 10
+const TWO_FACTOR_CHALLENGE_MAX_ATTEMPTS = 5
+
+// Personal API tokens. Scope is "<resource>:read" for GET and "<resource>:write" for other methods
+const API_TOKEN_PREFIX = "pat_"
+const API_TOKEN_TOUCH_INTERVAL_MILLI = 60 * 1000
+const SCOPE_PROFILE = "profile"
+const SCOPE_POSTS = "posts"
+const SCOPE_FOLLOWS = "follows"
+const SCOPE_NOTIFICATIONS = "notifications"
+const SCOPE_CHAT = "chat"
+const SCOPE_GROUPS = "groups"
+const SCOPE_EVENTS = "events"
+
+var apiTokenResources = []string{SCOPE_PROFILE, SCOPE_POSTS, SCOPE_FOLLOWS, SCOPE_NOTIFICATIONS, SCOPE_CHAT, SCOPE_GROUPS, SCOPE_EVENTS}
+
+// External sign in
+const OIDC_FAKE_PROVIDER = "fake"
+const OIDC_FAKE_CLIENT_ID = "my-social-network"
+const OIDC_BROWSER_COOKIE_NAME = "oidc_browser"
+
+// Security events
+const SECURITY_EVENT_LOGIN_FAILED = "login failed"
+const SECURITY_EVENT_ACCOUNT_LOCKED = "account locked"
+const SECURITY_EVENT_DELETION_CANCELLED = "account deletion cancelled"
+const SECURITY_EVENTS_LIMIT = 50
 
 const NOTIFICATION_FOLLOW_INFO = "notification follow info"
 const NOTIFICATION_FOLLOW_ACTION_REQUEST = "notification follow action request"
+const NOTIFICATION_REACTION = "notification reaction"
+const NOTIFICATION_MENTION = "notification mention"
+
+// Action status of follow request notifications
+const FOLLOW_REQUEST_PENDING = "pending"
+const FOLLOW_REQUEST_APPROVED = "approved"
+const FOLLOW_REQUEST_REJECTED = "rejected"
+const FOLLOW_REQUEST_CANCELLED = "cancelled"
 
 // Message Types
 const NEW_NOTIFICATION = "new notification"
+const EXPORT_READY = "export ready"
+const FOLLOW_REQUEST_UPDATED = "follow request updated"
+const NEW_POSTS_AVAILABLE = "new posts available"
+
+// Background jobs
+const JOB_TYPE_EXPORT = "export"
+const JOB_STATUS_PENDING = "pending"
+const JOB_STATUS_RUNNING = "running"
+const JOB_STATUS_READY = "ready"
+const JOB_STATUS_FAILED = "failed"
+const JOB_STATUS_EXPIRED = "expired"
 
 const FOLLOW_REQUEST_NOT_FOUND = "follow request not found"
 
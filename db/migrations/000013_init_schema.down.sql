@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "session" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL UNIQUE,
+    "session_id" TEXT);
+
+INSERT OR REPLACE INTO "session" (user_id, session_id)
+SELECT user_id, session_id FROM "sessions"
+WHERE revoked = false
+ORDER BY last_seen;
+
+DROP TABLE IF EXISTS "sessions";
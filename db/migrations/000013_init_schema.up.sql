@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" INTEGER PRIMARY KEY,
+    "session_id" TEXT NOT NULL UNIQUE,
+    "user_id" INTEGER NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    "last_seen" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL,
+    "user_agent" TEXT,
+    "ip" TEXT,
+    "revoked" BOOLEAN NOT NULL DEFAULT false);
+
+CREATE INDEX IF NOT EXISTS "sessions_user_id" ON "sessions" ("user_id");
+
+INSERT INTO "sessions" (session_id, user_id, created_at, last_seen, expires_at, user_agent, ip, revoked)
+SELECT
+    session_id,
+    user_id,
+    CAST(strftime('%s', 'now') AS INTEGER) * 1000,
+    CAST(strftime('%s', 'now') AS INTEGER) * 1000,
+    (CAST(strftime('%s', 'now') AS INTEGER) + 30 * 24 * 60 * 60) * 1000,
+    '',
+    '',
+    false
+FROM "session"
+WHERE session_id IS NOT NULL AND session_id != '';
+
+DROP TABLE IF EXISTS "session";
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS "password_reset_tokens" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "token_hash" TEXT NOT NULL UNIQUE,
+    "created_at" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL,
+    "used_at" INTEGER);
@@ -0,0 +1,13 @@
+ALTER TABLE "users" ADD COLUMN "email_verified" BOOLEAN NOT NULL DEFAULT false;
+
+-- Accounts created before verification was introduced are trusted
+UPDATE "users" SET email_verified = true;
+
+CREATE TABLE IF NOT EXISTS "email_verification_tokens" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "email" TEXT NOT NULL,
+    "token_hash" TEXT NOT NULL UNIQUE,
+    "created_at" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL,
+    "used_at" INTEGER);
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS "login_attempts" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER,
+    "ip" TEXT NOT NULL,
+    "success" BOOLEAN NOT NULL,
+    "created_at" INTEGER NOT NULL);
+
+CREATE INDEX IF NOT EXISTS "login_attempts_user_id" ON "login_attempts" ("user_id", "created_at");
+CREATE INDEX IF NOT EXISTS "login_attempts_ip" ON "login_attempts" ("ip", "created_at");
+
+CREATE TABLE IF NOT EXISTS "security_events" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "type" TEXT NOT NULL,
+    "ip" TEXT NOT NULL,
+    "user_agent" TEXT NOT NULL,
+    "created_at" INTEGER NOT NULL);
+
+CREATE INDEX IF NOT EXISTS "security_events_user_id" ON "security_events" ("user_id", "created_at");
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS "two_factor" (
+    "user_id" INTEGER PRIMARY KEY,
+    "secret" TEXT NOT NULL,
+    "enabled" BOOLEAN NOT NULL DEFAULT false,
+    "last_step" INTEGER NOT NULL DEFAULT 0,
+    "created_at" INTEGER NOT NULL,
+    "enabled_at" INTEGER);
+
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "code_hash" TEXT NOT NULL,
+    "used_at" INTEGER);
+
+CREATE INDEX IF NOT EXISTS "recovery_codes_user_id" ON "recovery_codes" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "two_factor_challenges" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "token_hash" TEXT NOT NULL UNIQUE,
+    "created_at" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL,
+    "attempts" INTEGER NOT NULL DEFAULT 0,
+    "used_at" INTEGER);
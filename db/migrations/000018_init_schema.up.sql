@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS "external_identities" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "provider" TEXT NOT NULL,
+    "subject" TEXT NOT NULL,
+    "email" TEXT NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("provider", "subject"));
+
+CREATE INDEX IF NOT EXISTS "external_identities_user_id" ON "external_identities" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "oidc_states" (
+    "id" INTEGER PRIMARY KEY,
+    "state_hash" TEXT NOT NULL UNIQUE,
+    "provider" TEXT NOT NULL,
+    "code_verifier" TEXT NOT NULL,
+    "nonce" TEXT NOT NULL,
+    "user_id" INTEGER,
+    "created_at" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS "api_tokens" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "token_hash" TEXT NOT NULL UNIQUE,
+    "scopes" TEXT NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL,
+    "last_used_at" INTEGER NOT NULL DEFAULT 0);
+
+CREATE INDEX IF NOT EXISTS "api_tokens_user_id" ON "api_tokens" ("user_id");
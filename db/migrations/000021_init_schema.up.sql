@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS "jobs" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "type" TEXT NOT NULL,
+    "status" TEXT NOT NULL,
+    "file" TEXT NOT NULL DEFAULT '',
+    "error" TEXT NOT NULL DEFAULT '',
+    "created_at" INTEGER NOT NULL,
+    "updated_at" INTEGER NOT NULL,
+    "expires_at" INTEGER NOT NULL DEFAULT 0);
+
+CREATE INDEX IF NOT EXISTS "jobs_user_id" ON "jobs" ("user_id");
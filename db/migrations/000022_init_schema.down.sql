@@ -0,0 +1,3 @@
+ALTER TABLE "users" DROP COLUMN "about_me_visibility";
+ALTER TABLE "users" DROP COLUMN "date_of_birth_visibility";
+ALTER TABLE "users" DROP COLUMN "email_visibility";
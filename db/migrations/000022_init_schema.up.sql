@@ -0,0 +1,3 @@
+ALTER TABLE "users" ADD COLUMN "email_visibility" TEXT NOT NULL DEFAULT 'followers';
+ALTER TABLE "users" ADD COLUMN "date_of_birth_visibility" TEXT NOT NULL DEFAULT 'followers';
+ALTER TABLE "users" ADD COLUMN "about_me_visibility" TEXT NOT NULL DEFAULT 'public';
@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN "cover" TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS "user_blocks" (
+    "id" INTEGER PRIMARY KEY,
+    "blocker_id" INTEGER NOT NULL,
+    "blocked_id" INTEGER NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("blocker_id", "blocked_id"));
+
+CREATE INDEX IF NOT EXISTS "user_blocks_blocked_id" ON "user_blocks" ("blocked_id");
+
+CREATE TABLE IF NOT EXISTS "user_mutes" (
+    "id" INTEGER PRIMARY KEY,
+    "muter_id" INTEGER NOT NULL,
+    "muted_id" INTEGER NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("muter_id", "muted_id"));
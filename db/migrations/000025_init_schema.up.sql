@@ -0,0 +1,8 @@
+ALTER TABLE "notifications" ADD COLUMN "action_status" TEXT NOT NULL DEFAULT '';
+
+UPDATE "notifications" SET "action_status" = CASE
+    WHEN EXISTS (SELECT 1 FROM "followers" WHERE "follower" = "sender_id" AND "followee" = "recipient_id" AND "approved" = false) THEN 'pending'
+    WHEN EXISTS (SELECT 1 FROM "followers" WHERE "follower" = "sender_id" AND "followee" = "recipient_id" AND "approved" = true) THEN 'approved'
+    ELSE 'cancelled'
+END
+WHERE "type" = 'notification follow action request';
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS "audience_lists" (
+    "id" INTEGER PRIMARY KEY,
+    "owner_id" INTEGER NOT NULL,
+    "name" TEXT NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("owner_id", "name"));
+
+CREATE TABLE IF NOT EXISTS "audience_list_members" (
+    "id" INTEGER PRIMARY KEY,
+    "list_id" INTEGER NOT NULL,
+    "user_id" INTEGER NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("list_id", "user_id"));
+
+CREATE INDEX IF NOT EXISTS "audience_list_members_user_id" ON "audience_list_members" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "post_audience_lists" (
+    "id" INTEGER PRIMARY KEY,
+    "post_id" INTEGER NOT NULL,
+    "list_id" INTEGER NOT NULL,
+    UNIQUE ("post_id", "list_id"));
+
+CREATE INDEX IF NOT EXISTS "post_audience_lists_list_id" ON "post_audience_lists" ("list_id");
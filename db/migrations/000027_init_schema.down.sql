@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS "followers_followee";
+DROP INDEX IF EXISTS "followers_follower";
+DROP INDEX IF EXISTS "posts_group_id";
+DROP INDEX IF EXISTS "posts_user_id";
+DROP INDEX IF EXISTS "posts_date";
+
+UPDATE "posts" SET "privacy" = (SELECT json_group_array("user_id") FROM "post_audience" WHERE "post_id" = "posts"."id") WHERE "privacy" = 'specific';
+
+DROP TABLE IF EXISTS "post_audience";
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS "post_audience" (
+    "id" INTEGER PRIMARY KEY,
+    "post_id" INTEGER NOT NULL,
+    "user_id" INTEGER NOT NULL,
+    UNIQUE ("post_id", "user_id"));
+
+CREATE INDEX IF NOT EXISTS "post_audience_user_id" ON "post_audience" ("user_id");
+
+INSERT OR IGNORE INTO "post_audience" ("post_id", "user_id")
+SELECT "posts"."id", CAST("json_each"."value" AS INTEGER)
+FROM "posts", json_each(CASE WHEN "posts"."privacy" LIKE '[%' AND json_valid("posts"."privacy") THEN "posts"."privacy" ELSE '[]' END) AS "json_each";
+
+UPDATE "posts" SET "privacy" = 'specific' WHERE "privacy" LIKE '[%' AND json_valid("privacy");
+
+CREATE INDEX IF NOT EXISTS "posts_date" ON "posts" ("date");
+CREATE INDEX IF NOT EXISTS "posts_user_id" ON "posts" ("user_id", "date");
+CREATE INDEX IF NOT EXISTS "posts_group_id" ON "posts" ("group_id", "date");
+CREATE INDEX IF NOT EXISTS "followers_follower" ON "followers" ("follower", "followee");
+CREATE INDEX IF NOT EXISTS "followers_followee" ON "followers" ("followee", "follower");
@@ -0,0 +1,12 @@
+ALTER TABLE "posts" ADD COLUMN "edited_at" INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "post_edits" (
+    "id" INTEGER PRIMARY KEY,
+    "post_id" INTEGER NOT NULL,
+    "editor_id" INTEGER NOT NULL,
+    "content" TEXT NOT NULL,
+    "privacy" TEXT NOT NULL,
+    "audience" TEXT NOT NULL DEFAULT '[]',
+    "edited_at" INTEGER NOT NULL);
+
+CREATE INDEX IF NOT EXISTS "post_edits_post_id" ON "post_edits" ("post_id", "edited_at");
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS "reactions" (
+    "id" INTEGER PRIMARY KEY,
+    "user_id" INTEGER NOT NULL,
+    "target_type" TEXT NOT NULL,
+    "target_id" INTEGER NOT NULL,
+    "type" TEXT NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("user_id", "target_type", "target_id"));
+
+CREATE INDEX IF NOT EXISTS "reactions_target" ON "reactions" ("target_type", "target_id");
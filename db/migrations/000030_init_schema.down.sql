@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS "comments_parent_id";
+DROP INDEX IF EXISTS "comments_post_id";
+ALTER TABLE "comments" DROP COLUMN "edited_at";
+ALTER TABLE "comments" DROP COLUMN "depth";
+ALTER TABLE "comments" DROP COLUMN "parent_id";
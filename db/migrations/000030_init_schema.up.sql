@@ -0,0 +1,6 @@
+ALTER TABLE "comments" ADD COLUMN "parent_id" INTEGER;
+ALTER TABLE "comments" ADD COLUMN "depth" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "comments" ADD COLUMN "edited_at" INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS "comments_post_id" ON "comments" ("post_id", "date");
+CREATE INDEX IF NOT EXISTS "comments_parent_id" ON "comments" ("parent_id", "date");
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS "mentions" (
+    "id" INTEGER PRIMARY KEY,
+    "source_type" TEXT NOT NULL,
+    "source_id" INTEGER NOT NULL,
+    "author_id" INTEGER NOT NULL,
+    "user_id" INTEGER NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("source_type", "source_id", "user_id"));
+
+CREATE INDEX IF NOT EXISTS "mentions_user_id" ON "mentions" ("user_id", "created_at");
+
+CREATE TABLE IF NOT EXISTS "hashtags" (
+    "id" INTEGER PRIMARY KEY,
+    "source_type" TEXT NOT NULL,
+    "source_id" INTEGER NOT NULL,
+    "author_id" INTEGER NOT NULL,
+    "tag" TEXT NOT NULL,
+    "created_at" INTEGER NOT NULL,
+    UNIQUE ("source_type", "source_id", "tag"));
+
+CREATE INDEX IF NOT EXISTS "hashtags_tag" ON "hashtags" ("tag", "created_at");
+CREATE INDEX IF NOT EXISTS "hashtags_created_at" ON "hashtags" ("created_at");
@@ -0,0 +1,388 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+)
+
+func ScheduleUserDeletion(userId int, deletionScheduledAt int64) (*int64, error) {
+	statement, err := db.Prepare("UPDATE users SET deletion_scheduled_at = ? WHERE id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(deletionScheduledAt, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Returns 1 if deletion was scheduled and is cancelled now
+func CancelUserDeletion(userId int) (*int64, error) {
+	statement, err := db.Prepare("UPDATE users SET deletion_scheduled_at = 0 WHERE id = ? AND deletion_scheduled_at > 0")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Ids of users whose grace period is over
+func GetUsersDueForDeletion(now int64) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM users WHERE deletion_scheduled_at > 0 AND deletion_scheduled_at <= ?", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Removes the user and everything the user owns in one transaction.
+// Groups created by the user are handed over to their first member (deleted if there is none),
+// the user is removed from member lists of groups, events, chat groups, read_by
+// and post audiences. Returns names of image files that are not referenced anymore,
+// so the caller can delete them after commit
+func DeleteUserData(userId int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	images := []string{}
+	collect := func(query string, args ...interface{}) error {
+		names, err := queryStrings(tx, query, args...)
+		if err != nil {
+			return err
+		}
+		images = append(images, names...)
+		return nil
+	}
+
+	//Images of user, user's posts, comments on them, user's comments and replies to them
+	err = collect("SELECT COALESCE(avatar, '') FROM users WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	err = collect("SELECT cover FROM users WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	err = collect("SELECT COALESCE(image, '') FROM posts WHERE user_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	err = collect("SELECT COALESCE(image, '') FROM comments WHERE id IN ("+userCommentTreeSql+")", userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	err = collect("SELECT COALESCE(image, '') FROM events WHERE creator_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+
+	//Groups created by the user
+	groupImages, err := handOverGroups(tx, userId)
+	if err != nil {
+		return nil, err
+	}
+	images = append(images, groupImages...)
+
+	//Chat groups left without members are deleted
+	emptyChatGroups, err := removeFromJsonArrays(tx, "chat_groups", "members", userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, chatGroupId := range emptyChatGroups {
+		err = collect("SELECT COALESCE(image, '') FROM chat_groups WHERE id = ?", chatGroupId)
+		if err != nil {
+			return nil, err
+		}
+		for _, query := range []string{
+			"DELETE FROM mentions WHERE source_type = 'message' AND source_id IN (SELECT id FROM messages WHERE chat_group_id = ?)",
+			"DELETE FROM hashtags WHERE source_type = 'message' AND source_id IN (SELECT id FROM messages WHERE chat_group_id = ?)",
+			"DELETE FROM messages WHERE chat_group_id = ?",
+			"DELETE FROM chat_groups WHERE id = ?",
+		} {
+			_, err = tx.Exec(query, chatGroupId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"DELETE FROM mentions WHERE user_id = ? OR author_id = ? OR " + userContentTagsSql, []interface{}{userId, userId, userId, userId, userId, userId, userId}},
+		{"DELETE FROM hashtags WHERE author_id = ? OR " + userContentTagsSql, []interface{}{userId, userId, userId, userId, userId, userId}},
+		{"DELETE FROM messages WHERE sender_id = ? OR recipient_id = ?", []interface{}{userId, userId}},
+		{"DELETE FROM reactions WHERE user_id = ? OR " + userContentReactionsSql, []interface{}{userId, userId, userId, userId}},
+		{"DELETE FROM comments WHERE id IN (" + userCommentTreeSql + ")", []interface{}{userId, userId}},
+		{"DELETE FROM post_edits WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)", []interface{}{userId}},
+		{"DELETE FROM post_audience WHERE user_id = ? OR post_id IN (SELECT id FROM posts WHERE user_id = ?)", []interface{}{userId, userId}},
+		{"DELETE FROM post_audience_lists WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)", []interface{}{userId}},
+		{"DELETE FROM posts WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM audience_list_members WHERE user_id = ? OR list_id IN (SELECT id FROM audience_lists WHERE owner_id = ?)", []interface{}{userId, userId}},
+		{"DELETE FROM post_audience_lists WHERE list_id IN (SELECT id FROM audience_lists WHERE owner_id = ?)", []interface{}{userId}},
+		{"DELETE FROM audience_lists WHERE owner_id = ?", []interface{}{userId}},
+		{"DELETE FROM notifications WHERE event_id IN (SELECT id FROM events WHERE creator_id = ?)", []interface{}{userId}},
+		{"DELETE FROM events WHERE creator_id = ?", []interface{}{userId}},
+		{"DELETE FROM followers WHERE follower = ? OR followee = ?", []interface{}{userId, userId}},
+		{"DELETE FROM notifications WHERE sender_id = ? OR recipient_id = ?", []interface{}{userId, userId}},
+		{"DELETE FROM group_invites WHERE inviter_id = ? OR member_id = ?", []interface{}{userId, userId}},
+		{"DELETE FROM join_group_requests WHERE member_id = ?", []interface{}{userId}},
+		{"DELETE FROM sessions WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM password_reset_tokens WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM email_verification_tokens WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM login_attempts WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM security_events WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM two_factor WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM recovery_codes WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM two_factor_challenges WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM external_identities WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM oidc_states WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM api_tokens WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM jobs WHERE user_id = ?", []interface{}{userId}},
+		{"DELETE FROM user_blocks WHERE blocker_id = ? OR blocked_id = ?", []interface{}{userId, userId}},
+		{"DELETE FROM user_mutes WHERE muter_id = ? OR muted_id = ?", []interface{}{userId, userId}},
+		{"DELETE FROM users WHERE id = ?", []interface{}{userId}},
+	}
+	for _, s := range statements {
+		_, err = tx.Exec(s.query, s.args...)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", s.query, err)
+		}
+	}
+
+	//Member lists
+	for _, c := range [][2]string{{"groups", "members"}, {"events", "members"}, {"messages", "read_by"}} {
+		_, err = removeFromJsonArrays(tx, c[0], c[1], userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, image := range images {
+		if image != "" {
+			names = append(names, image)
+		}
+	}
+	return names, nil
+}
+
+// Groups of the user get the first member as new creator. Groups without members
+// are deleted with their posts, events, invites and requests. Returns their images
+func handOverGroups(tx *sql.Tx, userId int) ([]string, error) {
+	rows, err := tx.Query("SELECT id, COALESCE(members, '') FROM groups WHERE creator_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	groups := map[int][]int{}
+	for rows.Next() {
+		var id int
+		var membersStr string
+		err = rows.Scan(&id, &membersStr)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		members, err := parseIdArray(membersStr)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		groups[id] = members
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	images := []string{}
+	for groupId, members := range groups {
+		if len(members) > 0 {
+			b, err := json.Marshal(members[1:])
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.Exec("UPDATE groups SET creator_id = ?, members = ? WHERE id = ?", members[0], string(b), groupId)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		names, err := queryStrings(tx, "SELECT COALESCE(image, '') FROM posts WHERE group_id = ?", groupId)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, names...)
+		names, err = queryStrings(tx, "SELECT COALESCE(image, '') FROM comments WHERE post_id IN (SELECT id FROM posts WHERE group_id = ?)", groupId)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, names...)
+		names, err = queryStrings(tx, "SELECT COALESCE(image, '') FROM events WHERE CAST(group_id AS INTEGER) = ?", groupId)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, names...)
+
+		for _, query := range []string{
+			"DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id IN (SELECT id FROM posts WHERE group_id = ?))",
+			"DELETE FROM reactions WHERE target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE group_id = ?)",
+			"DELETE FROM mentions WHERE source_type = 'comment' AND source_id IN (SELECT id FROM comments WHERE post_id IN (SELECT id FROM posts WHERE group_id = ?))",
+			"DELETE FROM mentions WHERE source_type = 'post' AND source_id IN (SELECT id FROM posts WHERE group_id = ?)",
+			"DELETE FROM hashtags WHERE source_type = 'comment' AND source_id IN (SELECT id FROM comments WHERE post_id IN (SELECT id FROM posts WHERE group_id = ?))",
+			"DELETE FROM hashtags WHERE source_type = 'post' AND source_id IN (SELECT id FROM posts WHERE group_id = ?)",
+			"DELETE FROM comments WHERE post_id IN (SELECT id FROM posts WHERE group_id = ?)",
+			"DELETE FROM post_edits WHERE post_id IN (SELECT id FROM posts WHERE group_id = ?)",
+			"DELETE FROM posts WHERE group_id = ?",
+			"DELETE FROM events WHERE CAST(group_id AS INTEGER) = ?",
+			"DELETE FROM group_invites WHERE group_id = ?",
+			"DELETE FROM join_group_requests WHERE group_id = ?",
+			"DELETE FROM notifications WHERE group_id = ?",
+			"DELETE FROM groups WHERE id = ?",
+		} {
+			_, err = tx.Exec(query, groupId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return images, nil
+}
+
+// Removes id from JSON arrays of ids ("[1,2,3]") stored in table.column.
+// Non-array values are left untouched.
+// Returns ids of rows whose array became empty
+func removeFromJsonArrays(tx *sql.Tx, table string, column string, id int) ([]int, error) {
+	query := fmt.Sprintf("SELECT id, %v FROM %v WHERE %v LIKE '[%%' || ? || '%%]'", column, table, column)
+	rows, err := tx.Query(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := map[int]string{}
+	emptied := []int{}
+	for rows.Next() {
+		var rowId int
+		var value string
+		err = rows.Scan(&rowId, &value)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids, err := parseIdArray(value)
+		if err != nil {
+			//Not a list of ids
+			continue
+		}
+		rest := []int{}
+		for _, i := range ids {
+			if i != id {
+				rest = append(rest, i)
+			}
+		}
+		if len(rest) != len(ids) {
+			b, err := json.Marshal(rest)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			updated[rowId] = string(b)
+			if len(rest) == 0 {
+				emptied = append(emptied, rowId)
+			}
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for rowId, value := range updated {
+		_, err = tx.Exec(fmt.Sprintf("UPDATE %v SET %v = ? WHERE id = ?", table, column), value, rowId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return emptied, nil
+}
+
+func parseIdArray(value string) ([]int, error) {
+	ids := []int{}
+	if value == "" {
+		return ids, nil
+	}
+	err := json.Unmarshal([]byte(value), &ids)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
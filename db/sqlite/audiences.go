@@ -0,0 +1,201 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	types "my-social-network/types"
+)
+
+// Posts shared with an audience list the viewer is a member of
+const audiencePostsSql = `SELECT post_id FROM post_audience_lists
+	INNER JOIN audience_list_members
+	ON audience_list_members.list_id = post_audience_lists.list_id
+	WHERE audience_list_members.user_id = ?`
+
+func CreateAudienceList(ownerId int, name string, date int64) (*int64, error) {
+	statement, err := db.Prepare("INSERT INTO audience_lists (owner_id, name, created_at) VALUES(?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(ownerId, name, date)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func RenameAudienceList(listId int, ownerId int, name string) (*int64, error) {
+	statement, err := db.Prepare("UPDATE audience_lists SET name = ? WHERE id = ? AND owner_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(name, listId, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Deletes the list with its members. Posts shared only with this list stay visible to their author only
+func DeleteAudienceList(listId int, ownerId int) (*int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM audience_lists WHERE id = ? AND owner_id = ?", listId, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if num == 0 {
+		return &num, nil
+	}
+
+	for _, query := range []string{
+		"DELETE FROM audience_list_members WHERE list_id = ?",
+		"DELETE FROM post_audience_lists WHERE list_id = ?",
+	} {
+		_, err = tx.Exec(query, listId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func GetAudienceLists(ownerId int) ([]types.AudienceList, error) {
+	lists := []types.AudienceList{}
+
+	rows, err := db.Query(`
+	SELECT id, name, created_at, (SELECT COUNT(*) FROM audience_list_members WHERE list_id = audience_lists.id)
+	FROM audience_lists
+	WHERE owner_id = ?
+	ORDER BY name COLLATE NOCASE`, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		list := types.AudienceList{}
+		err = rows.Scan(&(list.Id), &(list.Name), &(list.Date), &(list.MemberCount))
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
+
+// Returns nil if the list does not exist or is owned by someone else
+func GetAudienceList(listId int, ownerId int) (*types.AudienceList, error) {
+	list := types.AudienceList{}
+	err := db.QueryRow("SELECT id, name, created_at FROM audience_lists WHERE id = ? AND owner_id = ?", listId, ownerId).Scan(&(list.Id), &(list.Name), &(list.Date))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := queryUserRelations(`
+	SELECT users.id, `+displayNameSql+`, COALESCE(users.avatar, ''), audience_list_members.created_at
+	FROM audience_list_members
+	INNER JOIN users
+	ON users.id = audience_list_members.user_id
+	WHERE
+	list_id = ?
+	ORDER BY
+	audience_list_members.created_at
+	DESC`, listId)
+	if err != nil {
+		return nil, err
+	}
+	list.Members = *members
+	list.MemberCount = len(list.Members)
+	return &list, nil
+}
+
+// True if every list in listIds is owned by ownerId. listIds must not contain duplicates
+func OwnsAudienceLists(ownerId int, listIds []int) (bool, error) {
+	if len(listIds) == 0 {
+		return true, nil
+	}
+
+	args := []interface{}{ownerId}
+	for _, id := range listIds {
+		args = append(args, id)
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(listIds)), ",")
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(DISTINCT id) FROM audience_lists WHERE owner_id = ? AND id IN ("+placeholders+")", args...).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count == len(listIds), nil
+}
+
+// Returns 0 if the user is already a member
+func AddAudienceListMember(listId int, userId int, date int64) (*int64, error) {
+	statement, err := db.Prepare("INSERT OR IGNORE INTO audience_list_members (list_id, user_id, created_at) VALUES(?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(listId, userId, date)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func RemoveAudienceListMember(listId int, userId int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM audience_list_members WHERE list_id = ? AND user_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(listId, userId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
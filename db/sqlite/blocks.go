@@ -0,0 +1,187 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+)
+
+// Authors whose content is hidden from the viewer: users who blocked the viewer
+const blockedBySql = "SELECT blocker_id FROM user_blocks WHERE blocked_id = ?"
+
+// Users left out of the viewer's feed and notifications: blocked in either direction or muted by the viewer
+const hiddenUsersSql = `SELECT blocker_id FROM user_blocks WHERE blocked_id = ?
+	UNION SELECT blocked_id FROM user_blocks WHERE blocker_id = ?
+	UNION SELECT muted_id FROM user_mutes WHERE muter_id = ?`
+
+// Blocks blockedId for blockerId and removes follows, group invites and audience list memberships between the two.
+// Returns 0 if already blocked
+func BlockUser(blockerId int, blockedId int, date int64) (*int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO user_blocks (blocker_id, blocked_id, created_at) VALUES(?,?,?)", blockerId, blockedId, date)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM followers WHERE (follower = ? AND followee = ?) OR (follower = ? AND followee = ?)",
+		"DELETE FROM group_invites WHERE (inviter_id = ? AND member_id = ?) OR (inviter_id = ? AND member_id = ?)",
+		"DELETE FROM audience_list_members WHERE (user_id = ? AND list_id IN (SELECT id FROM audience_lists WHERE owner_id = ?)) OR (user_id = ? AND list_id IN (SELECT id FROM audience_lists WHERE owner_id = ?))",
+	} {
+		_, err = tx.Exec(query, blockerId, blockedId, blockedId, blockerId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func UnblockUser(blockerId int, blockedId int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM user_blocks WHERE blocker_id = ? AND blocked_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(blockerId, blockedId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// True if blockerId has blocked blockedId
+func HasBlocked(blockerId int, blockedId int) (bool, error) {
+	return exists("SELECT 1 FROM user_blocks WHERE blocker_id = ? AND blocked_id = ? LIMIT 1", blockerId, blockedId)
+}
+
+// True if either of the users has blocked the other
+func IsBlockedBetween(userId1 int, userId2 int) (bool, error) {
+	return exists("SELECT 1 FROM user_blocks WHERE (blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?) LIMIT 1", userId1, userId2, userId2, userId1)
+}
+
+func GetBlockedUsers(blockerId int) (*[]types.UserRelation, error) {
+	return queryUserRelations(`
+	SELECT users.id, `+displayNameSql+`, COALESCE(users.avatar, ''), user_blocks.created_at
+	FROM user_blocks
+	INNER JOIN users
+	ON users.id = blocked_id
+	WHERE
+	blocker_id = ?
+	ORDER BY
+	user_blocks.created_at
+	DESC`, blockerId)
+}
+
+// Returns 0 if already muted
+func MuteUser(muterId int, mutedId int, date int64) (*int64, error) {
+	statement, err := db.Prepare("INSERT OR IGNORE INTO user_mutes (muter_id, muted_id, created_at) VALUES(?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(muterId, mutedId, date)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func UnmuteUser(muterId int, mutedId int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM user_mutes WHERE muter_id = ? AND muted_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(muterId, mutedId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func IsMuted(muterId int, mutedId int) (bool, error) {
+	return exists("SELECT 1 FROM user_mutes WHERE muter_id = ? AND muted_id = ? LIMIT 1", muterId, mutedId)
+}
+
+func GetMutedUsers(muterId int) (*[]types.UserRelation, error) {
+	return queryUserRelations(`
+	SELECT users.id, `+displayNameSql+`, COALESCE(users.avatar, ''), user_mutes.created_at
+	FROM user_mutes
+	INNER JOIN users
+	ON users.id = muted_id
+	WHERE
+	muter_id = ?
+	ORDER BY
+	user_mutes.created_at
+	DESC`, muterId)
+}
+
+func queryUserRelations(query string, args ...interface{}) (*[]types.UserRelation, error) {
+	relations := []types.UserRelation{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		relation := types.UserRelation{}
+		err = rows.Scan(
+			&(relation.User.Id),
+			&(relation.User.DisplayName),
+			&(relation.User.Avatar),
+			&(relation.Date))
+		if err != nil {
+			return nil, err
+		}
+		relations = append(relations, relation)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &relations, nil
+}
+
+func exists(query string, args ...interface{}) (bool, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	found := rows.Next()
+	err = rows.Err()
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
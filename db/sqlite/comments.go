@@ -1,14 +1,48 @@
 package sqlite
 
-import "my-social-network/types"
+import (
+	"database/sql"
+	"my-social-network/types"
+	"strconv"
+	"strings"
+)
 
+// Comments of the user and of the user's posts with all replies to them. Takes 2 args
+const userCommentTreeSql = `WITH RECURSIVE tree(id) AS (
+	SELECT id FROM comments WHERE user_id = ? OR post_id IN (SELECT id FROM posts WHERE user_id = ?)
+	UNION SELECT comments.id FROM comments INNER JOIN tree ON comments.parent_id = tree.id)
+	SELECT id FROM tree`
+
+// A comment with all replies to it. Takes 1 arg
+const commentTreeSql = `WITH RECURSIVE tree(id) AS (
+	SELECT id FROM comments WHERE id = ?
+	UNION SELECT comments.id FROM comments INNER JOIN tree ON comments.parent_id = tree.id)
+	SELECT id FROM tree`
+
+// Columns read by scanComments. Reply count leaves out users who blocked the viewer. Takes 1 arg
+const commentColumnsSql = `comments.id,
+	comments.date,
+	comments.user_id,
+	users.nick_name,
+	users.first_name,
+	users.last_name,
+	users.avatar,
+	comments.post_id,
+	comments.content,
+	comments.image,
+	comments.parent_id,
+	comments.depth,
+	comments.edited_at,
+	(SELECT COUNT(*) FROM comments replies WHERE replies.parent_id = comments.id AND replies.user_id NOT IN (` + blockedBySql + `)) AS reply_count`
+
+// Saves a comment or, with ParentId set, a reply. Depth has to be set by the caller
 func SaveComment(comment types.Comment) (*int64, error) {
-	statement, err := db.Prepare("INSERT INTO comments (date, user_id, post_id, content, image) VALUES (?,?,?,?,?)")
+	statement, err := db.Prepare("INSERT INTO comments (date, user_id, post_id, content, image, parent_id, depth) VALUES (?,?,?,?,?,?,?)")
 	if err != nil {
 		return nil, err
 	}
 	defer statement.Close()
-	res, err := statement.Exec(comment.Date, comment.User.(int), comment.PostId, comment.Content, comment.Image)
+	res, err := statement.Exec(comment.Date, comment.User.(int), comment.PostId, comment.Content, comment.Image, comment.ParentId, comment.Depth)
 	if err != nil {
 		return nil, err
 	}
@@ -20,20 +54,150 @@ func SaveComment(comment types.Comment) (*int64, error) {
 	return &row, nil
 }
 
-func GetComments(postId int) ([]types.Comment, error) {
+func UpdateComment(commentId int, content string, date int64) (*int64, error) {
+	statement, err := db.Prepare("UPDATE comments SET content = ?, edited_at = ? WHERE id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(content, date, commentId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Deletes the comment with its replies and their reactions, mentions and hashtags. Returns the number of deleted
+// comments and names of their images
+func DeleteComment(commentId int) (int64, []string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	images, err := queryStrings(tx, "SELECT COALESCE(image, '') FROM comments WHERE id IN ("+commentTreeSql+")", commentId)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (" + commentTreeSql + ")",
+		"DELETE FROM mentions WHERE source_type = 'comment' AND source_id IN (" + commentTreeSql + ")",
+		"DELETE FROM hashtags WHERE source_type = 'comment' AND source_id IN (" + commentTreeSql + ")",
+	} {
+		_, err = tx.Exec(query, commentId)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	result, err := tx.Exec("DELETE FROM comments WHERE id IN ("+commentTreeSql+")", commentId)
+	if err != nil {
+		return 0, nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	files := []string{}
+	for _, image := range images {
+		if image != "" {
+			files = append(files, image)
+		}
+	}
+	return num, files, nil
+}
+
+// Top level comments of the post or, with parentId, replies to that comment. Newest first.
+// Comments by users who blocked the viewer are left out
+func GetCommentsPage(postId int, parentId int, viewerId int, after *types.Cursor, limit int) ([]types.Comment, error) {
+	conditions := []string{"comments.post_id = ?", "comments.user_id NOT IN (" + blockedBySql + ")"}
+	args := []interface{}{viewerId, postId, viewerId}
+	if parentId == 0 {
+		conditions = append(conditions, "comments.parent_id IS NULL")
+	} else {
+		conditions = append(conditions, "comments.parent_id = ?")
+		args = append(args, parentId)
+	}
+	if after != nil {
+		date, err := strconv.ParseInt(after.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "(comments.date < ? OR (comments.date = ? AND comments.id < ?))")
+		args = append(args, date, date, after.Id)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`
+	SELECT
+		`+commentColumnsSql+`
+	FROM
+		comments
+	JOIN
+		users
+	ON
+		comments.user_id = users.id
+	WHERE
+		`+strings.Join(conditions, `
+	AND
+		`)+`
+	ORDER BY
+		comments.date DESC, comments.id DESC
+	LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanComments(rows)
+}
+
+// Returns nil if comment does not exist. User is the author id
+func GetCommentById(commentId int) (*types.Comment, error) {
+	comment := types.Comment{}
+	var userId int
+	var image interface{}
+	err := db.QueryRow("SELECT id, date, user_id, post_id, content, image, parent_id, depth, edited_at FROM comments WHERE id = ?", commentId).Scan(
+		&(comment.Id),
+		&(comment.Date),
+		&userId,
+		&(comment.PostId),
+		&(comment.Content),
+		&image,
+		&(comment.ParentId),
+		&(comment.Depth),
+		&(comment.EditedAt))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	comment.User = userId
+	if image != nil {
+		comment.Image = image.(string)
+	}
+	return &comment, nil
+}
+
+// Comments by users who blocked the viewer are left out
+func GetComments(postId int, viewerId int) ([]types.Comment, error) {
 	comments := []types.Comment{}
 	sql :=
 		`SELECT
-			comments.id,
-			comments.date,
-			comments.user_id,
-			users.nick_name,
-			users.first_name,
-			users.last_name,
-			users.avatar,
-			comments.post_id,
-			comments.content,
-			comments.image
+			` + commentColumnsSql + `
 		 FROM
 		 	comments
 		 JOIN
@@ -42,15 +206,103 @@ func GetComments(postId int) ([]types.Comment, error) {
 		 	comments.user_id = users.id
 		 WHERE
 		    comments.post_id = ?
+		 AND
+		    comments.user_id NOT IN (` + blockedBySql + `)
 		 ORDER BY
 		 	date
 		 DESC`
 
-	rows, err := db.Query(sql, postId)
+	rows, err := db.Query(sql, viewerId, postId, viewerId)
 	if err != nil {
 		return comments, err
 	}
 
+	defer rows.Close()
+
+	return scanComments(rows)
+}
+
+// Total number of comments and replies and the newest previewSize top level comments of each post.
+// Comments by users who blocked the viewer are left out
+func GetCommentPreviews(postIds []int, viewerId int, previewSize int) (map[int]int, map[int][]types.Comment, error) {
+	counts := map[int]int{}
+	previews := map[int][]types.Comment{}
+	if len(postIds) == 0 {
+		return counts, previews, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(postIds)), ",")
+	args := []interface{}{}
+	for _, id := range postIds {
+		args = append(args, id)
+	}
+	args = append(args, viewerId)
+
+	rows, err := db.Query(`
+	SELECT post_id, COUNT(*)
+	FROM comments
+	WHERE post_id IN (`+placeholders+`)
+	AND user_id NOT IN (`+blockedBySql+`)
+	GROUP BY post_id`, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var postId, count int
+		err = rows.Scan(&postId, &count)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		counts[postId] = count
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previewArgs := append([]interface{}{viewerId}, args...)
+	rows, err = db.Query(`
+	SELECT id, date, user_id, nick_name, first_name, last_name, avatar, post_id, content, image, parent_id, depth, edited_at, reply_count
+	FROM (
+		SELECT
+			`+commentColumnsSql+`,
+			ROW_NUMBER() OVER (PARTITION BY comments.post_id ORDER BY comments.date DESC, comments.id DESC) AS position
+		FROM
+			comments
+		JOIN
+			users
+		ON
+			comments.user_id = users.id
+		WHERE
+			comments.post_id IN (`+placeholders+`)
+		AND
+			comments.parent_id IS NULL
+		AND
+			comments.user_id NOT IN (`+blockedBySql+`)
+	)
+	WHERE position <= ?
+	ORDER BY date DESC, id DESC`, append(previewArgs, previewSize)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	comments, err := scanComments(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, comment := range comments {
+		previews[comment.PostId] = append(previews[comment.PostId], comment)
+	}
+	return counts, previews, nil
+}
+
+func scanComments(rows *sql.Rows) ([]types.Comment, error) {
+	comments := []types.Comment{}
+
+	var err error
 	var nickName string
 	var firstName string
 	var lastName string
@@ -70,7 +322,11 @@ func GetComments(postId int) ([]types.Comment, error) {
 			&(basicUserInfo.Avatar),
 			&(comment.PostId),
 			&(comment.Content),
-			&image)
+			&image,
+			&(comment.ParentId),
+			&(comment.Depth),
+			&(comment.EditedAt),
+			&(comment.ReplyCount))
 
 		if err != nil {
 			return nil, err
@@ -0,0 +1,170 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Rows of every table holding data of the user, keyed by file name of the export.
+// Rows are plain column -> value maps of the listed columns. Columns are listed explicitly so
+// secrets (password hashes, token hashes) never end up in exports: new columns have to be added here.
+// Also returns names of image files uploaded by the user
+func GetUserExportData(userId int) (map[string][]map[string]interface{}, []string, error) {
+
+	data := map[string][]map[string]interface{}{}
+
+	queries := map[string]string{
+		"posts":             "SELECT id, date, content, privacy, image, group_id FROM posts WHERE user_id = ?",
+		"comments":          "SELECT id, date, post_id, parent_id, content, image, edited_at FROM comments WHERE user_id = ?",
+		"followers":         "SELECT follower AS user_id, date, approved FROM followers WHERE followee = ?",
+		"following":         "SELECT followee AS user_id, date, approved FROM followers WHERE follower = ?",
+		"notifications":     "SELECT id, date, type, content, sender_id, group_id, event_id, is_read FROM notifications WHERE recipient_id = ?",
+		"private_messages":  "SELECT id, sender_id, recipient_id, date, content, is_read FROM messages WHERE chat_group_id IS NULL AND (sender_id = ? OR recipient_id = ?)",
+		"groups_created":    "SELECT id, date, title, description, members FROM groups WHERE creator_id = ?",
+		"events_created":    "SELECT id, group_id, create_date, event_date, image, title, description, members FROM events WHERE creator_id = ?",
+		"security_events":   "SELECT id, type, ip, user_agent, created_at FROM security_events WHERE user_id = ?",
+		"sessions":          "SELECT id, created_at, last_seen, expires_at, user_agent, ip FROM sessions WHERE user_id = ?",
+		"linked_identities": "SELECT id, provider, email, created_at FROM external_identities WHERE user_id = ?",
+		"blocked_users":     "SELECT blocked_id AS user_id, created_at FROM user_blocks WHERE blocker_id = ?",
+		"muted_users":       "SELECT muted_id AS user_id, created_at FROM user_mutes WHERE muter_id = ?",
+		"audience_lists":    "SELECT id, name, created_at FROM audience_lists WHERE owner_id = ?",
+		"audience_members":  "SELECT list_id, user_id, created_at FROM audience_list_members WHERE list_id IN (SELECT id FROM audience_lists WHERE owner_id = ?)",
+		"post_audiences":    "SELECT post_id, list_id FROM post_audience_lists WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)",
+		"post_audience":     "SELECT post_id, user_id FROM post_audience WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)",
+		"post_edits":        "SELECT id, post_id, editor_id, content, privacy, audience, edited_at FROM post_edits WHERE post_id IN (SELECT id FROM posts WHERE user_id = ?)",
+		"reactions":         "SELECT target_type, target_id, type, created_at FROM reactions WHERE user_id = ?",
+		"mentions":          "SELECT source_type, source_id, user_id, created_at FROM mentions WHERE author_id = ?",
+		"hashtags":          "SELECT source_type, source_id, tag, created_at FROM hashtags WHERE author_id = ?",
+	}
+	for name, query := range queries {
+		args := []interface{}{userId}
+		if strings.Count(query, "?") == 2 {
+			args = append(args, userId)
+		}
+		rows, err := queryMaps(query, args...)
+		if err != nil {
+			return nil, nil, err
+		}
+		data[name] = rows
+	}
+
+	//Memberships are stored as JSON arrays of ids
+	memberships := map[string]string{
+		"groups_member": "SELECT id, creator_id, date, title, description, members FROM groups WHERE members LIKE '[%' || ? || '%]'",
+		"events_member": "SELECT id, group_id, creator_id, event_date, title, description, members FROM events WHERE members LIKE '[%' || ? || '%]'",
+		"chat_groups":   "SELECT id, date, title, image, members FROM chat_groups WHERE members LIKE '[%' || ? || '%]'",
+	}
+	for name, query := range memberships {
+		rows, err := queryMaps(query, userId)
+		if err != nil {
+			return nil, nil, err
+		}
+		member := []map[string]interface{}{}
+		for _, row := range rows {
+			members, _ := row["members"].(string)
+			delete(row, "members")
+			if containsId(members, userId) {
+				member = append(member, row)
+			}
+		}
+		data[name] = member
+	}
+
+	//Messages in chat groups of the user
+	chatGroupMessages := []map[string]interface{}{}
+	for _, chatGroup := range data["chat_groups"] {
+		rows, err := queryMaps("SELECT id, chat_group_id, sender_id, date, content FROM messages WHERE chat_group_id = ?", chatGroup["id"])
+		if err != nil {
+			return nil, nil, err
+		}
+		chatGroupMessages = append(chatGroupMessages, rows...)
+	}
+	data["chat_group_messages"] = chatGroupMessages
+
+	images := []string{}
+	for _, query := range []string{
+		"SELECT COALESCE(avatar, '') FROM users WHERE id = ?",
+		"SELECT cover FROM users WHERE id = ?",
+		"SELECT COALESCE(image, '') FROM posts WHERE user_id = ?",
+		"SELECT COALESCE(image, '') FROM comments WHERE user_id = ?",
+		"SELECT COALESCE(image, '') FROM events WHERE creator_id = ?",
+	} {
+		rows, err := db.Query(query, userId)
+		if err != nil {
+			return nil, nil, err
+		}
+		for rows.Next() {
+			var image string
+			err = rows.Scan(&image)
+			if err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			if image != "" {
+				images = append(images, image)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return data, images, nil
+}
+
+func containsId(value string, id int) bool {
+	ids := []int{}
+	err := json.Unmarshal([]byte(value), &ids)
+	if err != nil {
+		return false
+	}
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+func queryMaps(query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		err = rows.Scan(pointers...)
+		if err != nil {
+			return nil, err
+		}
+
+		row := map[string]interface{}{}
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		result = append(result, row)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	types "my-social-network/types"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -85,6 +87,25 @@ func UpdateFollowers(follower int, followee int, privacy string) error {
 	return nil
 }
 
+// Deletes a follow request that is not approved yet. Returns 0 if there is none
+func DeleteFollowRequest(follower int, followee int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM followers WHERE follower = ? AND followee = ? AND approved = false")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(follower, followee)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
 func DeleteFollower(follower int, followee int) error {
 	statement, err := db.Prepare("DELETE FROM followers WHERE follower = ? AND followee = ?")
 
@@ -113,96 +134,108 @@ func DeleteFollower(follower int, followee int) error {
 	return nil
 }
 
-func GetFollowing(userId int) (*[]types.Following, error) {
-	if userId < 1 {
-		return nil, errors.New("invalid user id")
+// Users followed by userId, newest first. Pending requests are included unless approvedOnly.
+// Mutual is set when the followed user follows userId back
+func GetFollowing(userId int, approvedOnly bool, after *types.Cursor, limit int) ([]types.FollowEdge, error) {
+	filter := ""
+	if approvedOnly {
+		filter = "f.approved = true"
 	}
+	return queryFollowEdges("followee", "follower", userId, filter, after, limit)
+}
 
-	query := `
-	SELECT
-	users.id, users.first_name, users.last_name, users.nick_name, users.avatar, users.privacy, approved, date FROM followers
-	INNER JOIN
-	users
-	ON
-	users.id = followee
-	WHERE
-	follower = ?
-	ORDER BY
-	date
-	DESC`
-
-	rows, err := db.Query(query, userId)
-
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	followings := []types.Following{}
-
-	for rows.Next() {
-		user := types.User{}
-		following := types.Following{}
-		err = rows.Scan(&(user.Id), &(user.FirstName), &(user.LastName), &(user.NickName), &(user.Avatar), &(user.Privacy), &(following.Approved), &(following.Date))
-		if err != nil {
-			return nil, err
-		}
-		following.Following = &user
-		followings = append(followings, following)
+// Followers of userId, newest first. Pending requests are included unless approvedOnly.
+// Mutual is set when userId follows the follower back
+func GetFollowers(userId int, approvedOnly bool, after *types.Cursor, limit int) ([]types.FollowEdge, error) {
+	filter := ""
+	if approvedOnly {
+		filter = "f.approved = true"
 	}
+	return queryFollowEdges("follower", "followee", userId, filter, after, limit)
+}
 
-	err = rows.Err()
-	if err != nil {
-		return nil, err
+// Pending requests to follow userId (incoming) or sent by userId (outgoing), newest first
+func GetFollowRequests(userId int, incoming bool, after *types.Cursor, limit int) ([]types.FollowEdge, error) {
+	if incoming {
+		return queryFollowEdges("follower", "followee", userId, "f.approved = false", after, limit)
 	}
-	return &followings, nil
+	return queryFollowEdges("followee", "follower", userId, "f.approved = false", after, limit)
 }
 
-func GetFollowers(userId int) (*[]types.Follower, error) {
+// other is the column of listed users, own the column matching userId. filter is an extra condition
+func queryFollowEdges(other string, own string, userId int, filter string, after *types.Cursor, limit int) ([]types.FollowEdge, error) {
 	if userId < 1 {
 		return nil, errors.New("invalid user id")
 	}
 
+	conditions := []string{"f." + own + " = ?"}
+	args := []interface{}{userId, userId}
+	if filter != "" {
+		conditions = append(conditions, filter)
+	}
+	if after != nil {
+		date, err := strconv.ParseInt(after.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "(f.date < ? OR (f.date = ? AND f.id < ?))")
+		args = append(args, date, date, after.Id)
+	}
+	args = append(args, limit)
+
 	query := `
 	SELECT
-	users.id, users.first_name, users.last_name, users.nick_name, users.avatar, users.privacy, approved, date FROM followers
-	INNER JOIN
-	users
-	ON
-	users.id = follower
+	f.id, users.id, ` + displayNameSql + `, COALESCE(users.avatar, ''), f.date, f.approved,
+	EXISTS (SELECT 1 FROM followers back WHERE back.` + own + ` = users.id AND back.` + other + ` = ? AND back.approved = true)
+	FROM followers f
+	INNER JOIN users
+	ON users.id = f.` + other + `
 	WHERE
-	followee = ?
+	` + strings.Join(conditions, " AND ") + `
 	ORDER BY
-	date
-	DESC
-	`
-
-	rows, err := db.Query(query, userId)
+	f.date DESC, f.id DESC
+	LIMIT ?`
 
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	followers := []types.Follower{}
-
+	edges := []types.FollowEdge{}
 	for rows.Next() {
-		user := types.User{}
-		follower := types.Follower{}
-		err = rows.Scan(&(user.Id), &(user.FirstName), &(user.LastName), &(user.NickName), &(user.Avatar), &(user.Privacy), &(follower.Approved), &(follower.Date))
+		edge := types.FollowEdge{}
+		err = rows.Scan(
+			&(edge.Id),
+			&(edge.User.Id),
+			&(edge.User.DisplayName),
+			&(edge.User.Avatar),
+			&(edge.Date),
+			&(edge.Approved),
+			&(edge.Mutual))
 		if err != nil {
 			return nil, err
 		}
-		follower.Follower = &user
-		followers = append(followers, follower)
+		edges = append(edges, edge)
 	}
-
 	err = rows.Err()
 	if err != nil {
 		return nil, err
 	}
+	return edges, nil
+}
 
-	return &followers, nil
+// Approved followers and followings of userId
+func GetFollowCounts(userId int) (*types.FollowCounts, error) {
+	counts := types.FollowCounts{}
+	err := db.QueryRow(`
+	SELECT
+	(SELECT COUNT(*) FROM followers WHERE followee = ? AND approved = true),
+	(SELECT COUNT(*) FROM followers WHERE follower = ? AND approved = true)`, userId, userId).Scan(&(counts.Followers), &(counts.Following))
+	if err != nil {
+		return nil, err
+	}
+	return &counts, nil
 }
 
 func ApproveFollower(follower int, followee int) error {
@@ -240,3 +273,7 @@ func ApproveFollower(follower int, followee int) error {
 
 	return nil
 }
+
+func IsApprovedFollower(follower int, followee int) (bool, error) {
+	return exists("SELECT 1 FROM followers WHERE follower = ? AND followee = ? AND approved = true LIMIT 1", follower, followee)
+}
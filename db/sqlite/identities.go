@@ -0,0 +1,201 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+)
+
+func SaveExternalIdentity(identity types.ExternalIdentity) (*int64, error) {
+	statement, err := db.Prepare("INSERT INTO external_identities (user_id, provider, subject, email, created_at) VALUES(?,?,?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	res, err := statement.Exec(identity.UserId, identity.Provider, identity.Subject, identity.Email, identity.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func GetExternalIdentity(provider string, subject string) (*types.ExternalIdentity, error) {
+
+	query := `
+	SELECT id, user_id, provider, subject, email, created_at
+	FROM external_identities
+	WHERE
+	provider = ?
+	AND
+	subject = ?
+	LIMIT 1`
+
+	identities, err := queryExternalIdentities(query, provider, subject)
+	if err != nil {
+		return nil, err
+	}
+	if len(*identities) == 0 {
+		return nil, nil
+	}
+	return &(*identities)[0], nil
+}
+
+func GetExternalIdentitiesByUserId(userId int) (*[]types.ExternalIdentity, error) {
+
+	query := `
+	SELECT id, user_id, provider, subject, email, created_at
+	FROM external_identities
+	WHERE
+	user_id = ?
+	ORDER BY
+	created_at`
+
+	return queryExternalIdentities(query, userId)
+}
+
+func queryExternalIdentities(query string, args ...interface{}) (*[]types.ExternalIdentity, error) {
+
+	identities := []types.ExternalIdentity{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		identity := types.ExternalIdentity{}
+		err = rows.Scan(
+			&(identity.Id),
+			&(identity.UserId),
+			&(identity.Provider),
+			&(identity.Subject),
+			&(identity.Email),
+			&(identity.CreatedAt))
+
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &identities, nil
+}
+
+func DeleteExternalIdentity(userId int, id int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM external_identities WHERE id = ? AND user_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(id, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// userId is set when the flow links identity to signed in user
+func SaveOidcState(state types.OidcState, stateHash string) error {
+	statement, err := db.Prepare("INSERT INTO oidc_states (state_hash, provider, code_verifier, nonce, user_id, browser_hash, created_at, expires_at) VALUES(?,?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	var userId interface{} = nil
+	if state.UserId > 0 {
+		userId = state.UserId
+	}
+
+	_, err = statement.Exec(stateHash, state.Provider, state.CodeVerifier, state.Nonce, userId, state.BrowserHash, state.CreatedAt, state.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns and deletes the state, so every flow can be completed only once
+func TakeOidcState(stateHash string, now int64) (*types.OidcState, error) {
+
+	query := `
+	SELECT id, provider, code_verifier, nonce, COALESCE(user_id, 0), browser_hash, created_at, expires_at
+	FROM oidc_states
+	WHERE
+	state_hash = ?
+	AND
+	expires_at > ?
+	LIMIT 1`
+
+	rows, err := db.Query(query, stateHash, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var state *types.OidcState = nil
+	for rows.Next() {
+		state = &types.OidcState{}
+		err = rows.Scan(
+			&(state.Id),
+			&(state.Provider),
+			&(state.CodeVerifier),
+			&(state.Nonce),
+			&(state.UserId),
+			&(state.BrowserHash),
+			&(state.CreatedAt),
+			&(state.ExpiresAt))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if state == nil {
+		return nil, nil
+	}
+
+	result, err := db.Exec("DELETE FROM oidc_states WHERE id = ?", state.Id)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if num == 0 {
+		return nil, nil
+	}
+	return state, nil
+}
+
+func DeleteExpiredOidcStates(now int64) error {
+	statement, err := db.Prepare("DELETE FROM oidc_states WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(now)
+	if err != nil {
+		return err
+	}
+	return nil
+}
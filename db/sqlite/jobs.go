@@ -0,0 +1,136 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+)
+
+func SaveJob(job types.Job) (*int64, error) {
+	statement, err := db.Prepare("INSERT INTO jobs (user_id, type, status, created_at, updated_at) VALUES(?,?,?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	res, err := statement.Exec(job.UserId, job.Type, job.Status, job.CreatedAt, job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func UpdateJob(job types.Job) error {
+	query := `
+	UPDATE jobs SET status = ?, file = ?, error = ?, updated_at = ?, expires_at = ? WHERE id = ?`
+
+	statement, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(job.Status, job.File, job.Error, job.UpdatedAt, job.ExpiresAt, job.Id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetJobsByUserId(userId int, jobType string) (*[]types.Job, error) {
+
+	query := `
+	SELECT id, user_id, type, status, file, error, created_at, updated_at, expires_at
+	FROM jobs
+	WHERE
+	user_id = ?
+	AND
+	type = ?
+	ORDER BY
+	created_at
+	DESC`
+
+	return queryJobs(query, userId, jobType)
+}
+
+func GetJob(userId int, id int) (*types.Job, error) {
+
+	query := `
+	SELECT id, user_id, type, status, file, error, created_at, updated_at, expires_at
+	FROM jobs
+	WHERE
+	id = ?
+	AND
+	user_id = ?`
+
+	jobs, err := queryJobs(query, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(*jobs) == 0 {
+		return nil, nil
+	}
+	return &(*jobs)[0], nil
+}
+
+// Jobs in status that expired before now
+func GetExpiredJobs(status string, now int64) (*[]types.Job, error) {
+
+	query := `
+	SELECT id, user_id, type, status, file, error, created_at, updated_at, expires_at
+	FROM jobs
+	WHERE
+	status = ?
+	AND
+	expires_at <= ?`
+
+	return queryJobs(query, status, now)
+}
+
+func queryJobs(query string, args ...interface{}) (*[]types.Job, error) {
+
+	jobs := []types.Job{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		job := types.Job{}
+		err = rows.Scan(
+			&(job.Id),
+			&(job.UserId),
+			&(job.Type),
+			&(job.Status),
+			&(job.File),
+			&(job.Error),
+			&(job.CreatedAt),
+			&(job.UpdatedAt),
+			&(job.ExpiresAt))
+
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &jobs, nil
+}
+
+// Jobs interrupted by restart will never finish
+func FailUnfinishedJobs(statuses []string, failedStatus string, now int64) error {
+	for _, status := range statuses {
+		_, err := db.Exec("UPDATE jobs SET status = ?, error = 'interrupted', updated_at = ? WHERE status = ?", failedStatus, now, status)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
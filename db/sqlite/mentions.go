@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"strings"
+
+	types "my-social-network/types"
+)
+
+// Mentions and hashtags in the user's posts, comments on them, the user's comments and replies to them
+// and the user's chat messages. Takes 5 args
+const userContentTagsSql = `(source_type = 'post' AND source_id IN (SELECT id FROM posts WHERE user_id = ?))
+	OR (source_type = 'comment' AND source_id IN (` + userCommentTreeSql + `))
+	OR (source_type = 'message' AND source_id IN (SELECT id FROM messages WHERE sender_id = ? OR recipient_id = ?))`
+
+// Replaces the mentions and hashtags indexed for the source with the given ones. Mentions are
+// nicknames matched case-insensitively. Nicknames are not unique: ones shared by several users
+// mention nobody. The author is never mentioned. Tags already indexed keep
+// their date. Returns ids of users who were not mentioned in the source before
+func SaveContentTags(sourceType string, sourceId int, authorId int, nickNames []string, tags []string, date int64) ([]int, error) {
+	userIds := []int{}
+	if len(nickNames) > 0 {
+		args := []interface{}{}
+		for _, nickName := range nickNames {
+			args = append(args, nickName)
+		}
+		args = append(args, authorId)
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(nickNames)), ",")
+		ids, err := queryInts(`
+		SELECT id FROM (
+			SELECT MIN(id) AS id
+			FROM users
+			WHERE nick_name COLLATE NOCASE IN (`+placeholders+`)
+			GROUP BY nick_name COLLATE NOCASE
+			HAVING COUNT(*) = 1)
+		WHERE id != ?`, args...)
+		if err != nil {
+			return nil, err
+		}
+		userIds = ids
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	mentioned := []int{}
+	args := []interface{}{sourceType, sourceId}
+	for _, userId := range userIds {
+		result, err := tx.Exec("INSERT OR IGNORE INTO mentions (source_type, source_id, author_id, user_id, created_at) VALUES(?,?,?,?,?)", sourceType, sourceId, authorId, userId, date)
+		if err != nil {
+			return nil, err
+		}
+		num, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if num > 0 {
+			mentioned = append(mentioned, userId)
+		}
+		args = append(args, userId)
+	}
+	_, err = tx.Exec("DELETE FROM mentions WHERE source_type = ? AND source_id = ? AND user_id NOT IN ("+strings.TrimSuffix(strings.Repeat("?,", len(userIds)), ",")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	args = []interface{}{sourceType, sourceId}
+	for _, tag := range tags {
+		_, err = tx.Exec("INSERT OR IGNORE INTO hashtags (source_type, source_id, author_id, tag, created_at) VALUES(?,?,?,?,?)", sourceType, sourceId, authorId, tag, date)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, tag)
+	}
+	_, err = tx.Exec("DELETE FROM hashtags WHERE source_type = ? AND source_id = ? AND tag NOT IN ("+strings.TrimSuffix(strings.Repeat("?,", len(tags)), ",")+")", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return mentioned, nil
+}
+
+// Hashtags of posts and comments the viewer may see, used since the given time.
+// Ranked by the number of distinct authors, then uses, then the latest use
+func GetTrendingHashtags(viewerId int, since int64, limit int) ([]types.HashtagTrend, error) {
+	trends := []types.HashtagTrend{}
+
+	args := []interface{}{since}
+	for i := 0; i < 7; i++ {
+		args = append(args, viewerId)
+	}
+	for i := 0; i < 8; i++ {
+		args = append(args, viewerId)
+	}
+	args = append(args, limit)
+
+	rows, err := db.Query(`
+	SELECT tag, COUNT(*), COUNT(DISTINCT author_id), MAX(created_at)
+	FROM hashtags
+	WHERE
+		created_at > ?
+	AND
+	(
+		(source_type = 'post' AND source_id IN (
+			SELECT posts.id FROM posts
+			LEFT JOIN groups ON groups.id = posts.group_id
+			WHERE `+postViewableSql+`))
+	OR
+		(source_type = 'comment' AND source_id IN (
+			SELECT comments.id FROM comments
+			INNER JOIN posts ON posts.id = comments.post_id
+			LEFT JOIN groups ON groups.id = posts.group_id
+			WHERE `+postViewableSql+`
+			AND comments.user_id NOT IN (`+blockedBySql+`)))
+	)
+	GROUP BY tag
+	ORDER BY COUNT(DISTINCT author_id) DESC, COUNT(*) DESC, MAX(created_at) DESC
+	LIMIT ?`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		trend := types.HashtagTrend{}
+		err = rows.Scan(&(trend.Tag), &(trend.Uses), &(trend.Authors), &(trend.LastUsed))
+		if err != nil {
+			return nil, err
+		}
+		trends = append(trends, trend)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return trends, nil
+}
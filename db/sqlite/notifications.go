@@ -11,8 +11,8 @@ func SaveNotification(n types.Notification) error {
 
 	query := `
 	INSERT INTO notifications
-	(date, type, content, sender_id, recipient_id, is_read, group_id, event_id)
-	VALUES(?,?,?,?,?,?,?,?)	
+	(date, type, content, sender_id, recipient_id, is_read, group_id, event_id, action_status)
+	VALUES(?,?,?,?,?,?,?,?,?)	
 	`
 	statement, err := db.Prepare(query)
 
@@ -101,7 +101,7 @@ func SaveNotification(n types.Notification) error {
 		}
 	*/
 
-	_, err = statement.Exec(date, n.Type, n.Content, senderId, recipientId, false, groupId, eventId)
+	_, err = statement.Exec(date, n.Type, n.Content, senderId, recipientId, false, groupId, eventId, n.ActionStatus)
 
 	if err != nil {
 		return err
@@ -126,14 +126,17 @@ func GetNotifications(recipient interface{}) (*[]types.Notification, error) {
 			notifications.recipient_id,
 			notifications.group_id,
 			notifications.event_id,
-			notifications.is_read
+			notifications.is_read,
+			notifications.action_status
 			FROM
 			notifications
 			WHERE
 			recipient_id = ?
+			AND
+			sender_id NOT IN (` + hiddenUsersSql + `)
 			ORDER BY date DESC
 		`
-	rows, err := db.Query(sql, recipientAsUser.Id)
+	rows, err := db.Query(sql, recipientAsUser.Id, recipientAsUser.Id, recipientAsUser.Id, recipientAsUser.Id)
 
 	if err != nil {
 		fmt.Println(err)
@@ -151,7 +154,8 @@ func GetNotifications(recipient interface{}) (*[]types.Notification, error) {
 			&(notification.Recipient),
 			&(notification.Group),
 			&(notification.Event),
-			&(notification.IsRead))
+			&(notification.IsRead),
+			&(notification.ActionStatus))
 		if err != nil {
 			fmt.Println(err)
 			return nil, err
@@ -176,6 +180,26 @@ func GetNotifications(recipient interface{}) (*[]types.Notification, error) {
 	return &notifications, nil
 }
 
+// Moves pending notifications of type from sender to recipient to status,
+// e.g. a follow request that was approved or cancelled. senderId 0 matches every sender
+func UpdateNotificationActionStatus(notificationType string, senderId int, recipientId int, status string) (*int64, error) {
+	statement, err := db.Prepare("UPDATE notifications SET action_status = ? WHERE type = ? AND (sender_id = ? OR ? = 0) AND recipient_id = ? AND action_status = 'pending'")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(status, notificationType, senderId, senderId, recipientId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
 func DeleteNotifications() error {
 	statement, err := db.Prepare("DELETE FROM notifications")
 
@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+)
+
+func SavePasswordResetToken(userId int, tokenHash string, createdAt int64, expiresAt int64) (*int64, error) {
+	statement, err := db.Prepare("INSERT INTO password_reset_tokens (user_id, token_hash, created_at, expires_at) VALUES(?,?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	res, err := statement.Exec(userId, tokenHash, createdAt, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func GetPasswordResetToken(tokenHash string) (*types.PasswordResetToken, error) {
+
+	query := `
+	SELECT id, user_id, created_at, expires_at, used_at
+	FROM password_reset_tokens
+	WHERE
+	token_hash = ?
+	LIMIT 1`
+
+	rows, err := db.Query(query, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var token *types.PasswordResetToken = nil
+	var usedAt interface{}
+
+	for rows.Next() {
+		token = &types.PasswordResetToken{}
+		err = rows.Scan(
+			&(token.Id),
+			&(token.UserId),
+			&(token.CreatedAt),
+			&(token.ExpiresAt),
+			&usedAt)
+		if err != nil {
+			return nil, err
+		}
+		if usedAt != nil {
+			token.UsedAt = usedAt.(int64)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Marks token as used. Returns 0 rows if token was already used or has expired
+func UsePasswordResetToken(id int, now int64) (*int64, error) {
+	query := `
+	UPDATE password_reset_tokens
+	SET used_at = ?
+	WHERE id = ? AND used_at IS NULL AND expires_at > ?`
+
+	statement, err := db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	res, err := statement.Exec(now, id, now)
+	if err != nil {
+		return nil, err
+	}
+	num, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func DeletePasswordResetTokensByUserId(userId int) error {
+	statement, err := db.Prepare("DELETE FROM password_reset_tokens WHERE user_id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
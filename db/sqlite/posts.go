@@ -2,32 +2,53 @@ package sqlite
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	types "my-social-network/types"
+	"strconv"
+	"strings"
 
 	"time"
 )
 
-func SavePost(post types.Post) (*int64, error) {
+// Non-group posts the viewer may see: own, public, private ones of followed users,
+// specific ones listing the viewer and ones shared with an audience list the viewer is in. Takes 4 args
+const postVisibleSql = `posts.user_id = ?
+	OR posts.privacy = 'public'
+	OR (posts.privacy = 'private' AND EXISTS (SELECT 1 FROM followers WHERE follower = ? AND followee = posts.user_id AND approved = true))
+	OR (posts.privacy = 'specific' AND EXISTS (SELECT 1 FROM post_audience WHERE post_audience.post_id = posts.id AND post_audience.user_id = ?))
+	OR (posts.privacy = 'audience' AND posts.id IN (` + audiencePostsSql + `))`
+
+// Viewer is the creator or a member of the joined groups row. Takes 2 args
+const groupMemberSql = `groups.creator_id = ?
+	OR EXISTS (SELECT 1 FROM json_each(CASE WHEN json_valid(groups.members) THEN groups.members ELSE '[]' END) WHERE json_each.value = ?)`
+
+// Posts the viewer may see: by privacy or, for group posts, as a member of the joined groups row.
+// Posts of users who blocked the viewer are left out. Takes 7 args
+const postViewableSql = `(
+		(posts.group_id IS NULL AND (` + postVisibleSql + `))
+	OR
+		(posts.group_id IS NOT NULL AND (` + groupMemberSql + `))
+	)
+	AND
+		posts.user_id NOT IN (` + blockedBySql + `)`
 
-	statement, err := db.Prepare("INSERT INTO posts (date, user_id, content, privacy, image) VALUES(?,?,?,?,?)")
-	if post.Group != nil {
-		statement, err = db.Prepare("INSERT INTO posts (date, user_id, content, privacy, image, group_id) VALUES(?,?,?,?,?,?)")
-	}
+// Saves the post with the users (specific posts) or the lists (audience posts) it is shared with
+func SavePost(post types.Post) (*int64, error) {
 
+	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
 	}
-
-	defer statement.Close()
+	defer tx.Rollback()
 
 	date := time.Now().UnixNano() / 1000000
 
 	var res sql.Result
 	if post.Group == nil {
-		res, err = statement.Exec(date, post.User.Id, post.Content, post.Privacy, post.Image)
+		res, err = tx.Exec("INSERT INTO posts (date, user_id, content, privacy, image) VALUES(?,?,?,?,?)", date, post.User.Id, post.Content, post.Privacy, post.Image)
 	} else {
-		res, err = statement.Exec(date, post.User.Id, post.Content, post.Privacy, post.Image, post.Group.(int))
+		res, err = tx.Exec("INSERT INTO posts (date, user_id, content, privacy, image, group_id) VALUES(?,?,?,?,?,?)", date, post.User.Id, post.Content, post.Privacy, post.Image, post.Group.(int))
 	}
 
 	if err != nil {
@@ -40,84 +61,154 @@ func SavePost(post types.Post) (*int64, error) {
 		return nil, err
 	}
 
-	return &lid, nil
-}
+	for _, userId := range post.Audience {
+		_, err = tx.Exec("INSERT OR IGNORE INTO post_audience (post_id, user_id) VALUES(?,?)", lid, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-func GetAllPostsRespectPrivacy(userId int) (*[]types.Post, error) {
-	posts := []types.Post{}
+	for _, listId := range post.AudienceLists {
+		_, err = tx.Exec("INSERT OR IGNORE INTO post_audience_lists (post_id, list_id) VALUES(?,?)", lid, listId)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	postsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLoggedUserAndPrivateExcluseLoggedUser, err := GetPostsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLoggedUserAndPrivatePostsExcludeLoggedUser(userId)
+	err = tx.Commit()
 	if err != nil {
-		fmt.Println("err12 ", err)
 		return nil, err
 	}
-	posts = append(posts, *postsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLoggedUserAndPrivateExcluseLoggedUser...)
-	return &posts, nil
+
+	return &lid, nil
 }
 
-func GetPostsByUserIdNoPrivacy(userId int) (*[]types.Post, error) {
-	fmt.Println("Own Profile")
-	//Show all posts by logged in user
-	postOfLoggedUser, err := GetPostsByUserId(userId)
+// Returns 0 if post does not exist
+func GetPostAuthorId(postId int) (int, error) {
+	var userId int
+	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postId).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		fmt.Println("err1 ", err)
-		return nil, err
+		return 0, err
 	}
-	fmt.Println("Own Posts: ", postOfLoggedUser)
-	return postOfLoggedUser, nil
+	return userId, nil
 }
 
-func GetPostsByUserIdRespectPrivacy(personId int, currentUserId int) (*[]types.Post, error) {
-	posts := []types.Post{}
-	fmt.Println("Profile Of ", personId)
-
-	publicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts, err := GetPublicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts(personId, currentUserId)
+// Home feed: own posts, posts the viewer may see by privacy and posts of groups the viewer belongs to.
+// Group posts carry types.GroupBasicInfo
+func GetAllPostsRespectPrivacy(userId int) (*[]types.Post, error) {
+	posts, err := GetFeedPage(userId, types.FeedQuery{})
 	if err != nil {
-		fmt.Println("err21 ", err)
 		return nil, err
 	}
-	posts = append(posts, *publicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts...)
-
 	return &posts, nil
 }
 
-func GetPostsByUserId(id int) (*[]types.Post, error) {
-	posts := []types.Post{}
-
-	sql := `
-	SELECT posts.id, date, user_id, users.nick_name, users.first_name, users.last_name, users.avatar, users.privacy, content, posts.privacy, image
+// True if the viewer may see the post: by its privacy or, for group posts, as a group member.
+// Posts of users who blocked the viewer are not visible
+func CanViewPost(viewerId int, postId int) (bool, error) {
+	return exists(`
+	SELECT 1
 	FROM posts
-	INNER JOIN users
-	ON user_id = users.id
-	WHERE user_id = ?
-	ORDER BY date DESC`
+	LEFT JOIN groups
+	ON groups.id = posts.group_id
+	WHERE
+		posts.id = ?
+	AND
+		`+postViewableSql, postId, viewerId, viewerId, viewerId, viewerId, viewerId, viewerId, viewerId)
+}
 
-	rows, err := db.Query(sql, id)
+// Users among userIds whose home feed has the post: followers for private posts, listed users for
+// specific posts, audience list members for audience posts, group members for group posts and anyone
+// for public posts. The author and users the author is hidden from (blocks both ways, mutes) are left out
+func GetFeedUserIds(postId int, userIds []int) ([]int, error) {
+	if len(userIds) == 0 {
+		return []int{}, nil
+	}
 
-	if err != nil {
-		return nil, err
+	args := []interface{}{postId}
+	for _, id := range userIds {
+		args = append(args, id)
 	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(userIds)), ",")
+
+	return queryInts(`
+	WITH post AS (
+		SELECT id, user_id, privacy, group_id FROM posts WHERE id = ?
+	),
+	viewers AS (
+		SELECT follower AS user_id FROM followers, post
+		WHERE post.group_id IS NULL AND post.privacy = 'private' AND followee = post.user_id AND approved = true
+		UNION
+		SELECT post_audience.user_id FROM post_audience, post
+		WHERE post.group_id IS NULL AND post.privacy = 'specific' AND post_audience.post_id = post.id
+		UNION
+		SELECT audience_list_members.user_id FROM post_audience_lists
+		INNER JOIN audience_list_members
+		ON audience_list_members.list_id = post_audience_lists.list_id
+		INNER JOIN post
+		ON post_audience_lists.post_id = post.id
+		WHERE post.group_id IS NULL AND post.privacy = 'audience'
+		UNION
+		SELECT groups.creator_id FROM groups, post
+		WHERE groups.id = post.group_id
+		UNION
+		SELECT json_each.value FROM groups, post, json_each(CASE WHEN json_valid(groups.members) THEN groups.members ELSE '[]' END)
+		WHERE groups.id = post.group_id
+	),
+	hiding AS (
+		SELECT blocked_id AS user_id FROM user_blocks, post WHERE blocker_id = post.user_id
+		UNION
+		SELECT blocker_id FROM user_blocks, post WHERE blocked_id = post.user_id
+		UNION
+		SELECT muter_id FROM user_mutes, post WHERE muted_id = post.user_id
+	)
+	SELECT users.id
+	FROM users, post
+	WHERE
+		users.id IN (`+placeholders+`)
+	AND
+		users.id != post.user_id
+	AND
+		(users.id IN (SELECT user_id FROM viewers) OR (post.group_id IS NULL AND post.privacy = 'public'))
+	AND
+		users.id NOT IN (SELECT user_id FROM hiding)`, args...)
+}
 
-	for rows.Next() {
-		post := types.Post{}
-		err = rows.Scan(&(post.Id), &(post.Date), &(post.User.Id), &(post.User.NickName), &(post.User.FirstName), &(post.User.LastName), &(post.User.Avatar), &(post.User.Privacy), &(post.Content), &(post.Privacy), &(post.Image))
+func GetFeedPage(userId int, query types.FeedQuery) ([]types.Post, error) {
+	posts := []types.Post{}
+
+	conditions := []string{}
+	args := []interface{}{userId, userId, userId, userId, userId, userId, userId, userId, userId}
+	if query.PostId != 0 {
+		conditions = append(conditions, "posts.id = ?")
+		args = append(args, query.PostId)
+	}
+	if query.Hashtag != "" {
+		conditions = append(conditions, "posts.id IN (SELECT source_id FROM hashtags WHERE source_type = 'post' AND tag = ?)")
+		args = append(args, query.Hashtag)
+	}
+	if query.Since != 0 {
+		conditions = append(conditions, "posts.date > ?")
+		args = append(args, query.Since)
+	}
+	if query.Until != 0 {
+		conditions = append(conditions, "posts.date <= ?")
+		args = append(args, query.Until)
+	}
+	if query.After != nil {
+		date, err := strconv.ParseInt(query.After.Value, 10, 64)
 		if err != nil {
 			return nil, err
 		}
-		posts = append(posts, post)
-	}
-	err = rows.Err()
-	if err != nil {
-		return nil, err
+		conditions = append(conditions, "(posts.date < ? OR (posts.date = ? AND posts.id < ?))")
+		args = append(args, date, date, query.After.Id)
 	}
-	return &posts, nil
-}
-
-func GetPostsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLoggedUserAndPrivatePostsExcludeLoggedUser(userId int) (*[]types.Post, error) {
-	posts := []types.Post{}
 
 	sql := `
-	SELECT 
+	SELECT
 		posts.id,
 		posts.date,
 		user_id,
@@ -129,97 +220,59 @@ func GetPostsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLog
 		content,
 		posts.privacy,
 		image,
-		group_id
+		posts.edited_at,
+		groups.id,
+		groups.title,
+		groups.description
 	FROM
 		posts
 	JOIN
 		users
 	ON
 		user_id = users.id
+	LEFT JOIN
+		groups
+	ON
+		groups.id = posts.group_id
 	WHERE
-		user_id = ?
-	OR	
-		user_id = (SELECT creator_id FROM groups WHERE groups.id = group_id)
-	OR
-		user_id IN (SELECT members FROM groups WHERE  groups.id = group_id)	
-	OR
-		(posts.privacy = 'public' AND user_id != ?)
-	OR
-		posts.privacy LIKE '[' || ? || ']' 
-	OR
-		posts.privacy LIKE '[%,' || ? || ']' 
-	OR
-		posts.privacy LIKE '[' || ? || ',%]'
-	OR
-		posts.privacy LIKE '[%,' || ? || ',%]'
-	OR
 	(
-		posts.privacy = 'private'
-		AND
-		user_id != ?
-		AND
-		user_id IN (SELECT users.id FROM followers INNER JOIN users ON users.id = followee WHERE approved = true AND follower = ?)
-	) 
-	ORDER BY
-		date
-	DESC`
-
-	/*
-		sql := `
-		SELECT
-			posts.id,
-			posts.date,
-			user_id,
-			users.nick_name,
-			users.first_name,
-			users.last_name,
-			users.avatar,
-			users.privacy,
-			content,
-			posts.privacy,
-			image,
-			group_id
-		FROM
-			posts
-		JOIN
-			users
-		ON
-			user_id = users.id
-		WHERE
-			user_id = ?
-		OR
-			(posts.privacy = 'public' AND user_id != ?)
-		OR
-			posts.privacy LIKE '[' || ? || ']'
-		OR
-			posts.privacy LIKE '[%,' || ? || ']'
-		OR
-			posts.privacy LIKE '[' || ? || ',%]'
-		OR
-			posts.privacy LIKE '[%,' || ? || ',%]'
-		OR
 		(
-			posts.privacy = 'private'
+			posts.group_id IS NULL
 			AND
-			user_id != ?
+			(` + postVisibleSql + `)
+		)
+	OR
+		(
+			posts.group_id IS NOT NULL
 			AND
-			user_id IN (SELECT users.id FROM followers INNER JOIN users ON users.id = followee WHERE approved = true AND follower = ?)
+			(` + groupMemberSql + `)
 		)
-		ORDER BY
-			date
-		DESC`
-	*/
-
-	rows, err := db.Query(sql, userId, userId, userId, userId, userId, userId, userId, userId)
+	)
+	AND
+		user_id NOT IN (` + hiddenUsersSql + `)`
+	for _, condition := range conditions {
+		sql += `
+	AND
+		` + condition
+	}
+	sql += `
+	ORDER BY
+		posts.date DESC, posts.id DESC`
+	if query.Limit > 0 {
+		sql += `
+	LIMIT ?`
+		args = append(args, query.Limit)
+	}
 
+	rows, err := db.Query(sql, args...)
 	if err != nil {
 		return nil, err
 	}
-
-	var groupId interface{}
+	defer rows.Close()
 
 	for rows.Next() {
 		post := types.Post{}
+		var groupId, groupTitle, groupDescription interface{}
 		err = rows.Scan(
 			&(post.Id),
 			&(post.Date),
@@ -232,37 +285,22 @@ func GetPostsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLog
 			&(post.Content),
 			&(post.Privacy),
 			&(post.Image),
-			&groupId)
+			&(post.EditedAt),
+			&groupId,
+			&groupTitle,
+			&groupDescription)
 		if err != nil {
 			return nil, err
 		}
 
-		//Filter Group Posts
-		if groupId == nil {
-			post.Group = nil
-			posts = append(posts, post)
-		} else {
-
-			group, err := GetGroupById(int(groupId.(int64)))
-			if err != nil {
-				return nil, err
-			}
-			if group.Creator.(types.UserBasicInfo).Id == userId {
-				post.Group = group
-				posts = append(posts, post)
+		if groupId != nil {
+			post.Group = types.GroupBasicInfo{
+				Id:          int(groupId.(int64)),
+				Title:       groupTitle.(string),
+				Description: groupDescription.(string),
 			}
-			if group.Members != nil {
-				for _, m := range group.Members.([]types.UserBasicInfo) {
-					if m.Id == userId {
-						post.Group = group
-						posts = append(posts, post)
-						break
-					}
-				}
-			}
-
 		}
-
+		posts = append(posts, post)
 	}
 
 	err = rows.Err()
@@ -270,6 +308,64 @@ func GetPostsByUserIdAndPublicPostsExcludeLoggedUserAndSpecificFriendsExcludeLog
 		return nil, err
 	}
 
+	return posts, nil
+}
+
+func GetPostsByUserIdNoPrivacy(userId int) (*[]types.Post, error) {
+	fmt.Println("Own Profile")
+	//Show all posts by logged in user
+	postOfLoggedUser, err := GetPostsByUserId(userId)
+	if err != nil {
+		fmt.Println("err1 ", err)
+		return nil, err
+	}
+	fmt.Println("Own Posts: ", postOfLoggedUser)
+	return postOfLoggedUser, nil
+}
+
+func GetPostsByUserIdRespectPrivacy(personId int, currentUserId int) (*[]types.Post, error) {
+	posts := []types.Post{}
+	fmt.Println("Profile Of ", personId)
+
+	publicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts, err := GetPublicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts(personId, currentUserId)
+	if err != nil {
+		fmt.Println("err21 ", err)
+		return nil, err
+	}
+	posts = append(posts, *publicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts...)
+
+	return &posts, nil
+}
+
+func GetPostsByUserId(id int) (*[]types.Post, error) {
+	posts := []types.Post{}
+
+	sql := `
+	SELECT posts.id, date, user_id, users.nick_name, users.first_name, users.last_name, users.avatar, users.privacy, content, posts.privacy, image, posts.edited_at
+	FROM posts
+	INNER JOIN users
+	ON user_id = users.id
+	WHERE user_id = ?
+	ORDER BY date DESC`
+
+	rows, err := db.Query(sql, id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		post := types.Post{}
+		err = rows.Scan(&(post.Id), &(post.Date), &(post.User.Id), &(post.User.NickName), &(post.User.FirstName), &(post.User.LastName), &(post.User.Avatar), &(post.User.Privacy), &(post.Content), &(post.Privacy), &(post.Image), &(post.EditedAt))
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
 	return &posts, nil
 }
 
@@ -277,32 +373,20 @@ func GetPublicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts(person
 	posts := []types.Post{}
 
 	sql := `
-	SELECT posts.id, date, user_id, users.nick_name, users.first_name, users.last_name, users.avatar, users.privacy, content, posts.privacy, image
+	SELECT posts.id, date, user_id, users.nick_name, users.first_name, users.last_name, users.avatar, users.privacy, content, posts.privacy, image, posts.edited_at
 	FROM posts
 	INNER JOIN users
 	ON user_id = users.id
 	WHERE
-	(posts.privacy = 'public' AND user_id = ?)
-	OR
-	posts.privacy LIKE '[' || ? || ']' 
-	OR
-	posts.privacy LIKE '[%,' || ? || ']' 
-	OR
-	posts.privacy LIKE '[' || ? || ',%]'
-	OR
-	posts.privacy LIKE '[%,' || ? || ',%]'
-	OR
-		(
-			posts.privacy = 'private'
-			AND
-			user_id = ?
-			AND
-			user_id IN (SELECT users.id FROM followers INNER JOIN users ON users.id = followee WHERE approved = true AND follower = ?)
-		)  
+		user_id = ?
+	AND
+		(` + postVisibleSql + `)
+	AND
+	user_id NOT IN (` + blockedBySql + `)
 	ORDER BY date DESC
 	`
 
-	rows, err := db.Query(sql, personId, currentUserId, currentUserId, currentUserId, currentUserId, personId, currentUserId)
+	rows, err := db.Query(sql, personId, currentUserId, currentUserId, currentUserId, currentUserId, currentUserId)
 
 	if err != nil {
 		return nil, err
@@ -310,7 +394,7 @@ func GetPublicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts(person
 
 	for rows.Next() {
 		post := types.Post{}
-		err = rows.Scan(&(post.Id), &(post.Date), &(post.User.Id), &(post.User.NickName), &(post.User.FirstName), &(post.User.LastName), &(post.User.Avatar), &(post.User.Privacy), &(post.Content), &(post.Privacy), &(post.Image))
+		err = rows.Scan(&(post.Id), &(post.Date), &(post.User.Id), &(post.User.NickName), &(post.User.FirstName), &(post.User.LastName), &(post.User.Avatar), &(post.User.Privacy), &(post.Content), &(post.Privacy), &(post.Image), &(post.EditedAt))
 		if err != nil {
 			return nil, err
 		}
@@ -325,7 +409,8 @@ func GetPublicPostsByUserIdAndPrivateSpecificPostsByUserIdAndPrivatePosts(person
 	return &posts, nil
 }
 
-func GetPostsByGroupId(groupId int) (*[]types.GroupPost, error) {
+// Posts by users who blocked the viewer are left out
+func GetPostsByGroupId(groupId int, viewerId int) (*[]types.GroupPost, error) {
 	posts := []types.GroupPost{}
 
 	sql := `
@@ -339,14 +424,16 @@ func GetPostsByGroupId(groupId int) (*[]types.GroupPost, error) {
 	  users.last_name,
 	  users.avatar,
 	  content,
-	  posts.image
+	  posts.image,
+	  posts.edited_at
 	FROM posts
 	INNER JOIN users
 	ON user_id = users.id
 	WHERE group_id = ?
+	AND user_id NOT IN (` + blockedBySql + `)
 	ORDER BY date DESC`
 
-	rows, err := db.Query(sql, groupId)
+	rows, err := db.Query(sql, groupId, viewerId)
 
 	if err != nil {
 		fmt.Println(err)
@@ -355,7 +442,7 @@ func GetPostsByGroupId(groupId int) (*[]types.GroupPost, error) {
 
 	for rows.Next() {
 		post := types.GroupPost{}
-		err = rows.Scan(&(post.Id), &(post.GroupId), &(post.Date), &(post.User.Id), &(post.User.NickName), &(post.User.FirstName), &(post.User.LastName), &(post.User.Avatar), &(post.Content), &(post.Image))
+		err = rows.Scan(&(post.Id), &(post.GroupId), &(post.Date), &(post.User.Id), &(post.User.NickName), &(post.User.FirstName), &(post.User.LastName), &(post.User.Avatar), &(post.Content), &(post.Image), &(post.EditedAt))
 		if err != nil {
 			fmt.Println(err)
 			return nil, err
@@ -370,3 +457,193 @@ func GetPostsByGroupId(groupId int) (*[]types.GroupPost, error) {
 
 	return &posts, nil
 }
+
+// Returns nil if post does not exist. Group is the group id or nil,
+// Audience and AudienceLists are set for specific and audience posts
+func GetPostById(postId int) (*types.Post, error) {
+	post := types.Post{}
+	var image, groupId interface{}
+	err := db.QueryRow("SELECT id, date, user_id, content, privacy, image, group_id, edited_at FROM posts WHERE id = ?", postId).Scan(
+		&(post.Id),
+		&(post.Date),
+		&(post.User.Id),
+		&(post.Content),
+		&(post.Privacy),
+		&image,
+		&groupId,
+		&(post.EditedAt))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if image != nil {
+		post.Image = image.(string)
+	}
+	if groupId != nil {
+		post.Group = int(groupId.(int64))
+	}
+
+	post.Audience, err = queryInts("SELECT user_id FROM post_audience WHERE post_id = ? ORDER BY user_id", postId)
+	if err != nil {
+		return nil, err
+	}
+	post.AudienceLists, err = queryInts("SELECT list_id FROM post_audience_lists WHERE post_id = ? ORDER BY list_id", postId)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// Saves the current content and privacy of the post to its edit history, then replaces them
+// with post.Content, post.Privacy and the post's audience
+func UpdatePost(post types.Post, editorId int, date int64) (*int64, error) {
+	previous, err := GetPostById(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	if previous == nil {
+		var num int64
+		return &num, nil
+	}
+
+	audience := previous.Audience
+	if previous.Privacy == "audience" {
+		audience = previous.AudienceLists
+	}
+	b, err := json.Marshal(audience)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO post_edits (post_id, editor_id, content, privacy, audience, edited_at) VALUES(?,?,?,?,?,?)", post.Id, editorId, previous.Content, previous.Privacy, string(b), date)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec("UPDATE posts SET content = ?, privacy = ?, edited_at = ? WHERE id = ?", post.Content, post.Privacy, date, post.Id)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM post_audience WHERE post_id = ?",
+		"DELETE FROM post_audience_lists WHERE post_id = ?",
+	} {
+		_, err = tx.Exec(query, post.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, userId := range post.Audience {
+		_, err = tx.Exec("INSERT OR IGNORE INTO post_audience (post_id, user_id) VALUES(?,?)", post.Id, userId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, listId := range post.AudienceLists {
+		_, err = tx.Exec("INSERT OR IGNORE INTO post_audience_lists (post_id, list_id) VALUES(?,?)", post.Id, listId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Deletes the post with its comments, reactions, mentions, hashtags, audience and edit history.
+// Returns names of the post and comment images
+func DeletePost(postId int) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	images, err := queryStrings(tx, "SELECT COALESCE(image, '') FROM posts WHERE id = ?", postId)
+	if err != nil {
+		return nil, err
+	}
+	names, err := queryStrings(tx, "SELECT COALESCE(image, '') FROM comments WHERE post_id = ?", postId)
+	if err != nil {
+		return nil, err
+	}
+	images = append(images, names...)
+
+	for _, query := range []string{
+		"DELETE FROM reactions WHERE target_type = 'comment' AND target_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM reactions WHERE target_type = 'post' AND target_id = ?",
+		"DELETE FROM mentions WHERE source_type = 'comment' AND source_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM mentions WHERE source_type = 'post' AND source_id = ?",
+		"DELETE FROM hashtags WHERE source_type = 'comment' AND source_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM hashtags WHERE source_type = 'post' AND source_id = ?",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM post_audience WHERE post_id = ?",
+		"DELETE FROM post_audience_lists WHERE post_id = ?",
+		"DELETE FROM post_edits WHERE post_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	} {
+		_, err = tx.Exec(query, postId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{}
+	for _, image := range images {
+		if image != "" {
+			files = append(files, image)
+		}
+	}
+	return files, nil
+}
+
+// Previous versions of the post, newest first
+func GetPostEdits(postId int) ([]types.PostEdit, error) {
+	edits := []types.PostEdit{}
+
+	rows, err := db.Query("SELECT id, editor_id, content, privacy, audience, edited_at FROM post_edits WHERE post_id = ? ORDER BY edited_at DESC, id DESC", postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		edit := types.PostEdit{}
+		var audience string
+		err = rows.Scan(&(edit.Id), &(edit.EditorId), &(edit.Content), &(edit.Privacy), &audience, &(edit.Date))
+		if err != nil {
+			return nil, err
+		}
+		edit.Audience, err = parseIdArray(audience)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, edit)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
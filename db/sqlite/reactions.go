@@ -0,0 +1,116 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	types "my-social-network/types"
+)
+
+// Reactions on the user's posts, comments on them, the user's comments and replies to them. Takes 3 args
+const userContentReactionsSql = `(target_type = 'post' AND target_id IN (SELECT id FROM posts WHERE user_id = ?))
+	OR (target_type = 'comment' AND target_id IN (` + userCommentTreeSql + `))`
+
+// Sets the user's reaction on the target. Reacting again with the same type removes the reaction.
+// Returns the previous and the current reaction type, "" if none
+func ToggleReaction(userId int, targetType string, targetId int, reactionType string, date int64) (string, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	previous := ""
+	err = tx.QueryRow("SELECT type FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?", userId, targetType, targetId).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
+	}
+
+	current := reactionType
+	if previous == reactionType {
+		current = ""
+		_, err = tx.Exec("DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?", userId, targetType, targetId)
+	} else {
+		_, err = tx.Exec(`INSERT INTO reactions (user_id, target_type, target_id, type, created_at) VALUES(?,?,?,?,?)
+		ON CONFLICT (user_id, target_type, target_id) DO UPDATE SET type = excluded.type, created_at = excluded.created_at`,
+			userId, targetType, targetId, reactionType, date)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", "", err
+	}
+	return previous, current, nil
+}
+
+func DeleteReaction(userId int, targetType string, targetId int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM reactions WHERE user_id = ? AND target_type = ? AND target_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(userId, targetType, targetId)
+	if err != nil {
+		return nil, err
+	}
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Reaction counts per type and the viewer's own reaction for each target.
+// Every target id gets a summary. Reactions by users who blocked the viewer are left out
+func GetReactionSummaries(targetType string, targetIds []int, viewerId int) (map[int]*types.ReactionSummary, error) {
+	summaries := map[int]*types.ReactionSummary{}
+	if len(targetIds) == 0 {
+		return summaries, nil
+	}
+
+	args := []interface{}{viewerId, targetType}
+	for _, id := range targetIds {
+		summaries[id] = &types.ReactionSummary{Counts: map[string]int{}}
+		args = append(args, id)
+	}
+	args = append(args, viewerId)
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(targetIds)), ",")
+
+	rows, err := db.Query(`
+	SELECT target_id, type, COUNT(*), MAX(user_id = ?)
+	FROM reactions
+	WHERE
+	target_type = ?
+	AND target_id IN (`+placeholders+`)
+	AND user_id NOT IN (`+blockedBySql+`)
+	GROUP BY target_id, type`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var targetId, count int
+		var reactionType string
+		var mine bool
+		err = rows.Scan(&targetId, &reactionType, &count, &mine)
+		if err != nil {
+			return nil, err
+		}
+		summary := summaries[targetId]
+		summary.Counts[reactionType] = count
+		summary.Total += count
+		if mine {
+			summary.Mine = reactionType
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
@@ -0,0 +1,146 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+)
+
+// userId is 0 when signin name did not match any account
+func SaveLoginAttempt(userId int, ip string, success bool, createdAt int64) error {
+	statement, err := db.Prepare("INSERT INTO login_attempts (user_id, ip, success, created_at) VALUES(?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	var user interface{} = nil
+	if userId > 0 {
+		user = userId
+	}
+
+	_, err = statement.Exec(user, ip, success, createdAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Failed attempts on the account since its last successful signin (but not before since).
+// Returns number of failures and time of the latest one
+func GetLoginFailuresByUserId(userId int, since int64) (int, int64, error) {
+
+	query := `
+	SELECT COUNT(*), COALESCE(MAX(created_at), 0)
+	FROM login_attempts
+	WHERE
+	user_id = ?
+	AND
+	success = false
+	AND
+	created_at > MAX(?, COALESCE((SELECT MAX(created_at) FROM login_attempts WHERE user_id = ? AND success = true), 0))`
+
+	var count int
+	var last int64
+	err := db.QueryRow(query, userId, since, userId).Scan(&count, &last)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, last, nil
+}
+
+// Failed attempts from the ip address after since.
+// Returns number of failures and time of the latest one
+func GetLoginFailuresByIp(ip string, since int64) (int, int64, error) {
+
+	query := `
+	SELECT COUNT(*), COALESCE(MAX(created_at), 0)
+	FROM login_attempts
+	WHERE
+	ip = ?
+	AND
+	success = false
+	AND
+	created_at > ?`
+
+	var count int
+	var last int64
+	err := db.QueryRow(query, ip, since).Scan(&count, &last)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, last, nil
+}
+
+func DeleteLoginAttemptsBefore(before int64) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM login_attempts WHERE created_at <= ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(before)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func SaveSecurityEvent(event types.SecurityEvent) error {
+	statement, err := db.Prepare("INSERT INTO security_events (user_id, type, ip, user_agent, created_at) VALUES(?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(event.UserId, event.Type, event.Ip, event.UserAgent, event.Date)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetSecurityEventsByUserId(userId int, limit int) (*[]types.SecurityEvent, error) {
+
+	events := []types.SecurityEvent{}
+
+	query := `
+	SELECT id, user_id, type, ip, user_agent, created_at
+	FROM security_events
+	WHERE
+	user_id = ?
+	ORDER BY
+	created_at
+	DESC
+	LIMIT ?`
+
+	rows, err := db.Query(query, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := types.SecurityEvent{}
+		err = rows.Scan(
+			&(event.Id),
+			&(event.UserId),
+			&(event.Type),
+			&(event.Ip),
+			&(event.UserAgent),
+			&(event.Date))
+
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &events, nil
+}
@@ -1,13 +1,15 @@
 package sqlite
 
-func SaveSession(userId int, sessionId string) error {
+import (
+	types "my-social-network/types"
+)
+
+func SaveSession(session types.UserSession) error {
 
 	query := `
-	INSERT INTO session(user_id, session_id)
-  	VALUES(?, ?)
-  	ON CONFLICT(user_id) DO UPDATE SET
-	session_id = ?   
-  	WHERE user_id = ?`
+	INSERT INTO sessions
+	(session_id, user_id, created_at, last_seen, expires_at, user_agent, ip, revoked)
+	VALUES(?, ?, ?, ?, ?, ?, ?, false)`
 
 	statement, err := db.Prepare(query)
 
@@ -17,76 +19,218 @@ func SaveSession(userId int, sessionId string) error {
 
 	defer statement.Close()
 
-	_, err = statement.Exec(userId, sessionId, sessionId, userId)
+	_, err = statement.Exec(
+		session.SessionId,
+		session.UserId,
+		session.CreatedAt,
+		session.LastSeen,
+		session.ExpiresAt,
+		session.UserAgent,
+		session.Ip)
 
 	if err != nil {
 		return err
 	}
 
-	/*
-		query := `UPDATE session SET session_id = ? WHERE user_id = ?`
+	return nil
+}
 
-		statement, err := db.Prepare(query)
+func GetSessionBySessionId(sessionId string) (*types.UserSession, error) {
 
-		if err != nil {
-			return err
-		}
-		defer statement.Close()
+	query := `
+	SELECT id, session_id, user_id, created_at, last_seen, expires_at, user_agent, ip, revoked
+	FROM sessions
+	WHERE
+	session_id = ?
+	LIMIT 1`
 
-		result, err := statement.Exec(sessionId, userId)
+	rows, err := db.Query(query, sessionId)
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var session *types.UserSession = nil
+	for rows.Next() {
+		session = &types.UserSession{}
+		err = rows.Scan(
+			&(session.Id),
+			&(session.SessionId),
+			&(session.UserId),
+			&(session.CreatedAt),
+			&(session.LastSeen),
+			&(session.ExpiresAt),
+			&(session.UserAgent),
+			&(session.Ip),
+			&(session.Revoked))
 
 		if err != nil {
-			return err
+			return nil, err
 		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func GetActiveSessionsByUserId(userId int, now int64) (*[]types.UserSession, error) {
+
+	sessions := []types.UserSession{}
+
+	query := `
+	SELECT id, session_id, user_id, created_at, last_seen, expires_at, user_agent, ip, revoked
+	FROM sessions
+	WHERE
+	user_id = ?
+	AND
+	revoked = false
+	AND
+	expires_at > ?
+	ORDER BY
+	last_seen
+	DESC`
+
+	rows, err := db.Query(query, userId, now)
 
-		id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session := types.UserSession{}
+		err = rows.Scan(
+			&(session.Id),
+			&(session.SessionId),
+			&(session.UserId),
+			&(session.CreatedAt),
+			&(session.LastSeen),
+			&(session.ExpiresAt),
+			&(session.UserAgent),
+			&(session.Ip),
+			&(session.Revoked))
 
 		if err != nil {
-			return err
+			return nil, err
 		}
+		sessions = append(sessions, session)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &sessions, nil
+}
 
-		fmt.Println("Update")
-		fmt.Println(id)
+func RevokeSession(userId int, id int) (*int64, error) {
+	query := `
+	UPDATE sessions SET revoked = true WHERE id = ? AND user_id = ? AND revoked = false`
 
-		if id == 0 {
-			query = `INSERT INTO session (user_id, session_id) VALUES(?, ?)`
+	statement, err := db.Prepare(query)
 
-			statement, err = db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
 
-			if err != nil {
-				return err
-			}
+	defer statement.Close()
 
-			defer statement.Close()
+	result, err := statement.Exec(id, userId)
 
-			result, err = statement.Exec(userId, sessionId)
+	if err != nil {
+		return nil, err
+	}
 
-			if err != nil {
-				return err
-			}
+	num, err := result.RowsAffected()
 
-			id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
 
-			if err != nil {
-				return err
-			}
+	return &num, nil
+}
 
-			if id != 1 {
-				return fmt.Errorf("session_id inset incorrect. Insert number: %v", id)
-			}
+// Revokes every session of the user. Session with id exceptSessionId is kept
+// (pass empty string to revoke all of them)
+func RevokeSessionsByUserId(userId int, exceptSessionId string) (*int64, error) {
+	query := `
+	UPDATE sessions SET revoked = true WHERE user_id = ? AND session_id != ? AND revoked = false`
 
-			fmt.Println("Inset")
-			fmt.Println(id)
+	statement, err := db.Prepare(query)
 
-		}
-	*/
+	if err != nil {
+		return nil, err
+	}
+
+	defer statement.Close()
 
+	result, err := statement.Exec(userId, exceptSessionId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &num, nil
+}
+
+func TouchSession(id int, lastSeen int64, expiresAt int64) error {
+	query := `
+	UPDATE sessions SET last_seen = ?, expires_at = ? WHERE id = ?`
+
+	statement, err := db.Prepare(query)
+
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+
+	_, err = statement.Exec(lastSeen, expiresAt, id)
+
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+func DeleteExpiredSessions(now int64) (*int64, error) {
+	query := `
+	DELETE FROM sessions WHERE expires_at <= ? OR revoked = true`
+
+	statement, err := db.Prepare(query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer statement.Close()
+
+	result, err := statement.Exec(now)
+
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &num, nil
+}
+
 func DeleteSession(sessionId string) error {
 	query := `
-		UPDATE session SET session_id = '' WHERE session_id = ?
+		DELETE FROM sessions WHERE session_id = ?
 	`
 
 	statement, err := db.Prepare(query)
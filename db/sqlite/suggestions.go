@@ -0,0 +1,132 @@
+package sqlite
+
+import (
+	"fmt"
+	types "my-social-network/types"
+)
+
+// Signals for follow suggestions, keyed by candidate id. Candidates are users the caller
+// does not follow or request to follow, not blocked in either direction and not scheduled for deletion
+func GetSuggestionSignals(userId int) (map[int]*types.SuggestionSignals, error) {
+
+	signals := map[int]*types.SuggestionSignals{}
+	get := func(id int) *types.SuggestionSignals {
+		if signals[id] == nil {
+			signals[id] = &types.SuggestionSignals{}
+		}
+		return signals[id]
+	}
+
+	//Friends of friends: followed by people the user follows
+	rows, err := db.Query(`
+	SELECT f2.followee, COUNT(*)
+	FROM followers f1
+	INNER JOIN followers f2
+	ON f2.follower = f1.followee
+	WHERE
+	f1.follower = ? AND f1.approved = true AND f2.approved = true
+	GROUP BY f2.followee`, userId)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, count int
+		err = rows.Scan(&id, &count)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		get(id).MutualFollows = count
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	//Groups: creator and members, events: attendees, chat groups: members.
+	//Only rows the user is in are read; every other member is counted once per row
+	memberLists := []struct {
+		query string
+		args  []interface{}
+		add   func(s *types.SuggestionSignals, count int)
+	}{
+		{`SELECT groups.id AS id, groups.creator_id AS member FROM groups WHERE ` + groupMemberSql + `
+		UNION SELECT groups.id, m.value FROM groups, ` + jsonMembersSql("groups.members") + ` m WHERE ` + groupMemberSql,
+			[]interface{}{userId, userId, userId, userId}, func(s *types.SuggestionSignals, count int) { s.SharedGroups += count }},
+		{`SELECT events.id AS id, m.value AS member FROM events, ` + jsonMembersSql("events.members") + ` m
+		WHERE EXISTS (SELECT 1 FROM ` + jsonMembersSql("events.members") + ` WHERE json_each.value = ?)`,
+			[]interface{}{userId}, func(s *types.SuggestionSignals, count int) { s.SharedEvents += count }},
+		{`SELECT chat_groups.id AS id, m.value AS member FROM chat_groups, ` + jsonMembersSql("chat_groups.members") + ` m
+		WHERE EXISTS (SELECT 1 FROM ` + jsonMembersSql("chat_groups.members") + ` WHERE json_each.value = ?)`,
+			[]interface{}{userId}, func(s *types.SuggestionSignals, count int) { s.SharedChatGroups += count }},
+	}
+	for _, list := range memberLists {
+		rows, err := db.Query("SELECT member, COUNT(DISTINCT id) FROM ("+list.query+") GROUP BY member", list.args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, count int
+			err = rows.Scan(&id, &count)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			list.add(get(id), count)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//Exclusions
+	excluded := []string{
+		"SELECT followee FROM followers WHERE follower = ?",
+		"SELECT blocked_id FROM user_blocks WHERE blocker_id = ?",
+		"SELECT blocker_id FROM user_blocks WHERE blocked_id = ?",
+		"SELECT id FROM users WHERE deletion_scheduled_at > 0 AND id != ?",
+	}
+	for _, query := range excluded {
+		ids, err := queryInts(query, userId)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", query, err)
+		}
+		for _, id := range ids {
+			delete(signals, id)
+		}
+	}
+	delete(signals, userId)
+
+	return signals, nil
+}
+
+// Ids of a JSON array column ("[1,2,3]") as json_each rows. Malformed values have no rows
+func jsonMembersSql(column string) string {
+	return "json_each(CASE WHEN json_valid(" + column + ") THEN " + column + " ELSE '[]' END)"
+}
+
+func queryInts(query string, args ...interface{}) ([]int, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []int{}
+	for rows.Next() {
+		var value int
+		err = rows.Scan(&value)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
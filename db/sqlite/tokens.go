@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+	"strings"
+)
+
+// Scopes are stored space separated
+func SaveApiToken(token types.ApiToken, tokenHash string) (*int64, error) {
+	statement, err := db.Prepare("INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at) VALUES(?,?,?,?,?,?)")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	res, err := statement.Exec(token.UserId, token.Name, tokenHash, strings.Join(token.Scopes, " "), token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func GetApiTokenByHash(tokenHash string) (*types.ApiToken, error) {
+
+	query := `
+	SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at
+	FROM api_tokens
+	WHERE
+	token_hash = ?
+	LIMIT 1`
+
+	tokens, err := queryApiTokens(query, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(*tokens) == 0 {
+		return nil, nil
+	}
+	return &(*tokens)[0], nil
+}
+
+func GetApiTokensByUserId(userId int) (*[]types.ApiToken, error) {
+
+	query := `
+	SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at
+	FROM api_tokens
+	WHERE
+	user_id = ?
+	ORDER BY
+	created_at
+	DESC`
+
+	return queryApiTokens(query, userId)
+}
+
+func queryApiTokens(query string, args ...interface{}) (*[]types.ApiToken, error) {
+
+	tokens := []types.ApiToken{}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		token := types.ApiToken{}
+		scopes := ""
+		err = rows.Scan(
+			&(token.Id),
+			&(token.UserId),
+			&(token.Name),
+			&scopes,
+			&(token.CreatedAt),
+			&(token.ExpiresAt),
+			&(token.LastUsedAt))
+
+		if err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Fields(scopes)
+		tokens = append(tokens, token)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+func TouchApiToken(id int, lastUsedAt int64) error {
+	statement, err := db.Prepare("UPDATE api_tokens SET last_used_at = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(lastUsedAt, id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func DeleteApiToken(userId int, id int) (*int64, error) {
+	statement, err := db.Prepare("DELETE FROM api_tokens WHERE id = ? AND user_id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(id, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func DeleteApiTokensByUserId(userId int) error {
+	statement, err := db.Prepare("DELETE FROM api_tokens WHERE user_id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
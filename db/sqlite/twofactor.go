@@ -0,0 +1,302 @@
+package sqlite
+
+import (
+	types "my-social-network/types"
+)
+
+// Saves new (not yet enabled) secret. Replaces unfinished enrollment
+func SaveTwoFactorSecret(userId int, secret string, createdAt int64) error {
+	query := `
+	INSERT OR REPLACE INTO two_factor
+	(user_id, secret, enabled, last_step, created_at, enabled_at)
+	VALUES(?, ?, false, 0, ?, NULL)`
+
+	statement, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(userId, secret, createdAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetTwoFactor(userId int) (*types.TwoFactor, error) {
+
+	query := `
+	SELECT user_id, secret, enabled, last_step, created_at, enabled_at
+	FROM two_factor
+	WHERE
+	user_id = ?
+	LIMIT 1`
+
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var twoFactor *types.TwoFactor = nil
+	var enabledAt interface{}
+
+	for rows.Next() {
+		twoFactor = &types.TwoFactor{}
+		err = rows.Scan(
+			&(twoFactor.UserId),
+			&(twoFactor.Secret),
+			&(twoFactor.Enabled),
+			&(twoFactor.LastStep),
+			&(twoFactor.CreatedAt),
+			&enabledAt)
+
+		if err != nil {
+			return nil, err
+		}
+		if enabledAt != nil {
+			twoFactor.EnabledAt = enabledAt.(int64)
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return twoFactor, nil
+}
+
+func EnableTwoFactor(userId int, enabledAt int64) error {
+	statement, err := db.Prepare("UPDATE two_factor SET enabled = true, enabled_at = ? WHERE user_id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(enabledAt, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Removes secret and recovery codes of the user
+func DeleteTwoFactor(userId int) error {
+	statement, err := db.Prepare("DELETE FROM two_factor WHERE user_id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(userId)
+	if err != nil {
+		return err
+	}
+
+	statement, err = db.Prepare("DELETE FROM recovery_codes WHERE user_id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Stores time step of accepted code. Returns 0 rows affected if the step
+// (or a later one) was used already, so one code can not be replayed
+func UseTotpStep(userId int, step int64) (*int64, error) {
+	statement, err := db.Prepare("UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(step, userId, step)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Replaces all recovery codes of the user with new ones
+func SaveRecoveryCodes(userId int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES(?,?)", userId, codeHash)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func GetUnusedRecoveryCodes(userId int) (*[]types.RecoveryCode, error) {
+
+	codes := []types.RecoveryCode{}
+
+	rows, err := db.Query("SELECT id, user_id, code_hash FROM recovery_codes WHERE user_id = ? AND used_at IS NULL", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		code := types.RecoveryCode{}
+		err = rows.Scan(
+			&(code.Id),
+			&(code.UserId),
+			&(code.CodeHash))
+
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &codes, nil
+}
+
+func UseRecoveryCode(id int, now int64) (*int64, error) {
+	statement, err := db.Prepare("UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(now, id)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func SaveTwoFactorChallenge(userId int, tokenHash string, createdAt int64, expiresAt int64) error {
+	statement, err := db.Prepare("INSERT INTO two_factor_challenges (user_id, token_hash, created_at, expires_at) VALUES(?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(userId, tokenHash, createdAt, expiresAt)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns challenge if it is still usable at now
+func GetTwoFactorChallenge(tokenHash string, now int64, maxAttempts int) (*types.TwoFactorChallenge, error) {
+
+	query := `
+	SELECT id, user_id, created_at, expires_at, attempts
+	FROM two_factor_challenges
+	WHERE
+	token_hash = ?
+	AND
+	used_at IS NULL
+	AND
+	expires_at > ?
+	AND
+	attempts < ?
+	LIMIT 1`
+
+	rows, err := db.Query(query, tokenHash, now, maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var challenge *types.TwoFactorChallenge = nil
+	for rows.Next() {
+		challenge = &types.TwoFactorChallenge{}
+		err = rows.Scan(
+			&(challenge.Id),
+			&(challenge.UserId),
+			&(challenge.CreatedAt),
+			&(challenge.ExpiresAt),
+			&(challenge.Attempts))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+func IncrementTwoFactorChallengeAttempts(id int) error {
+	statement, err := db.Prepare("UPDATE two_factor_challenges SET attempts = attempts + 1 WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func UseTwoFactorChallenge(id int, now int64) (*int64, error) {
+	statement, err := db.Prepare("UPDATE two_factor_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer statement.Close()
+
+	result, err := statement.Exec(now, id)
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+func DeleteExpiredTwoFactorChallenges(now int64) error {
+	statement, err := db.Prepare("DELETE FROM two_factor_challenges WHERE expires_at <= ? OR used_at IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+
+	_, err = statement.Exec(now)
+	if err != nil {
+		return err
+	}
+	return nil
+}
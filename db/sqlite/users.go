@@ -1,12 +1,41 @@
 package sqlite
 
 import (
-	"fmt"
+	"database/sql"
+	"errors"
 	types "my-social-network/types"
 	util "my-social-network/util"
 	"strings"
 )
 
+// Columns read by scanUser, in order
+const userColumns = "id, first_name, last_name, date_of_birth, nick_name, email, password, about_me, avatar, cover, privacy, email_verified, deletion_scheduled_at, email_visibility, date_of_birth_visibility, about_me_visibility"
+
+func scanUser(rows *sql.Rows) (*types.User, error) {
+	user := types.User{}
+	err := rows.Scan(
+		&(user.Id),
+		&(user.FirstName),
+		&(user.LastName),
+		&(user.DateOfBirth),
+		&(user.NickName),
+		&(user.Email),
+		&(user.Password),
+		&(user.AboutMe),
+		&(user.Avatar),
+		&(user.Cover),
+		&(user.Privacy),
+		&(user.EmailVerified),
+		&(user.DeletionScheduledAt),
+		&(user.EmailVisibility),
+		&(user.DateOfBirthVisibility),
+		&(user.AboutMeVisibility))
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func SaveUser(user *types.User) (int64, error) {
 
 	statement, err := db.Prepare("INSERT INTO users (first_name, last_name, date_of_birth, nick_name, email, password, about_me, avatar, privacy) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
@@ -16,13 +45,19 @@ func SaveUser(user *types.User) (int64, error) {
 	}
 	defer statement.Close()
 
+	//Accounts created by external sign in have no password. Empty hash never matches
+	password := ""
+	if user.Password != "" {
+		password = util.Encrypt(user.Password)
+	}
+
 	result, err := statement.Exec(
 		user.FirstName,
 		user.LastName,
 		user.DateOfBirth,
 		user.NickName,
 		strings.TrimSpace(strings.ToLower(user.Email)),
-		util.Encrypt(user.Password),
+		password,
 		user.AboutMe,
 		user.Avatar,
 		strings.TrimSpace(strings.ToLower(user.Privacy)))
@@ -44,13 +79,13 @@ func GetUserBySessionId(session_id string) (*types.User, error) {
 	}
 
 	query := `
-	SELECT * FROM users
+	SELECT ` + userColumns + ` FROM users
 	WHERE
 	id = 
-		(SELECT user_id FROM session WHERE session_id = ? LIMIT 1)
+		(SELECT user_id FROM sessions WHERE session_id = ? AND revoked = false AND expires_at > ? LIMIT 1)
 	LIMIT 1
 	`
-	rows, err := db.Query(query, session_id)
+	rows, err := db.Query(query, session_id, util.GetCurrentMilli())
 
 	if err != nil {
 		return nil, err
@@ -58,19 +93,7 @@ func GetUserBySessionId(session_id string) (*types.User, error) {
 	defer rows.Close()
 	var user *types.User = nil
 	for rows.Next() {
-		user = &types.User{}
-		err = rows.Scan(
-			&(user.Id),
-			&(user.FirstName),
-			&(user.LastName),
-			&(user.DateOfBirth),
-			&(user.NickName),
-			&(user.Email),
-			&(user.Password),
-			&(user.AboutMe),
-			&(user.Avatar),
-			&(user.Privacy))
-
+		user, err = scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -83,32 +106,20 @@ func GetUserBySessionId(session_id string) (*types.User, error) {
 }
 
 func GetUserByEmailOrNickNameAndPassword(user types.User) (*types.User, error) {
-	u := types.User{}
 
 	// Get By Email
-	rows, err := db.Query("SELECT * FROM users WHERE email = ?", strings.ToLower(strings.TrimSpace(user.NickName)))
+	rows, err := db.Query("SELECT "+userColumns+" FROM users WHERE email = ?", strings.ToLower(strings.TrimSpace(user.NickName)))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(
-			&(u.Id),
-			&(u.FirstName),
-			&(u.LastName),
-			&(u.DateOfBirth),
-			&(u.NickName),
-			&(u.Email),
-			&(u.Password),
-			&(u.AboutMe),
-			&(u.Avatar),
-			&(u.Privacy))
-
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		if util.CompairPasswords(u.Password, user.Password) {
-			return &u, nil
+			return u, nil
 		}
 	}
 	err = rows.Err()
@@ -116,29 +127,18 @@ func GetUserByEmailOrNickNameAndPassword(user types.User) (*types.User, error) {
 		return nil, err
 	}
 	// Get By Nick Name
-	rows, err = db.Query("SELECT * FROM users WHERE nick_name = ?", strings.TrimSpace(user.NickName))
+	rows, err = db.Query("SELECT "+userColumns+" FROM users WHERE nick_name = ?", strings.TrimSpace(user.NickName))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(
-			&(u.Id),
-			&(u.FirstName),
-			&(u.LastName),
-			&(u.DateOfBirth),
-			&(u.NickName),
-			&(u.Email),
-			&(u.Password),
-			&(u.AboutMe),
-			&(u.Avatar),
-			&(u.Privacy))
-
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		if util.CompairPasswords(u.Password, user.Password) {
-			return &u, nil
+			return u, nil
 		}
 	}
 	err = rows.Err()
@@ -148,49 +148,218 @@ func GetUserByEmailOrNickNameAndPassword(user types.User) (*types.User, error) {
 	return nil, nil
 }
 
-func UpdateUserDetails(id int, m map[string]string) (*int64, error) {
+// Updates fields set in update. Switching privacy to public approves pending follow requests
+func UpdateUserProfile(id int, update types.ProfileUpdate) (*int64, error) {
 
-	query := "UPDATE users SET "
+	columns := []string{}
+	values := []interface{}{}
 
-	values := make([]interface{}, 0)
+	set := func(column string, value interface{}) {
+		columns = append(columns, column+" = ?")
+		values = append(values, value)
+	}
 
-	for k, v := range m {
-		query += fmt.Sprintf("%v = ?, ", k)
-		values = append(values, v)
+	//New email has to be verified again. CASE sees the email before update
+	if update.Email != nil {
+		email := strings.TrimSpace(strings.ToLower(*update.Email))
+		columns = append(columns, "email_verified = CASE WHEN email = ? THEN email_verified ELSE false END")
+		values = append(values, email)
+		set("email", email)
+	}
+	if update.FirstName != nil {
+		set("first_name", *update.FirstName)
+	}
+	if update.LastName != nil {
+		set("last_name", *update.LastName)
+	}
+	if update.NickName != nil {
+		set("nick_name", *update.NickName)
+	}
+	if update.DateOfBirth != nil {
+		set("date_of_birth", *update.DateOfBirth)
+	}
+	if update.AboutMe != nil {
+		set("about_me", *update.AboutMe)
+	}
+	if update.Privacy != nil {
+		set("privacy", *update.Privacy)
+	}
+	if update.EmailVisibility != nil {
+		set("email_visibility", *update.EmailVisibility)
+	}
+	if update.DateOfBirthVisibility != nil {
+		set("date_of_birth_visibility", *update.DateOfBirthVisibility)
+	}
+	if update.AboutMeVisibility != nil {
+		set("about_me_visibility", *update.AboutMeVisibility)
 	}
-	values = append(values, id)
 
-	query = strings.TrimSuffix(query, ", ")
+	var num int64 = 0
+	if len(columns) == 0 {
+		return &num, nil
+	}
 
-	query += " WHERE id = ?"
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	statement, err := db.Prepare(query)
+	values = append(values, id)
+	result, err := tx.Exec("UPDATE users SET "+strings.Join(columns, ", ")+" WHERE id = ?", values...)
+	if err != nil {
+		return nil, err
+	}
 
+	num, err = result.RowsAffected()
 	if err != nil {
 		return nil, err
 	}
 
+	if update.Privacy != nil && *update.Privacy == "public" {
+		_, err = tx.Exec("UPDATE followers SET approved = true WHERE followee = ? AND approved = false", id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &num, nil
+}
+
+// Sets avatar or cover image. Returns the previous file name so it can be deleted
+func UpdateUserImage(id int, column string, fileName string) (string, error) {
+	if column != "avatar" && column != "cover" {
+		return "", errors.New("invalid image column")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRow("SELECT COALESCE("+column+", '') FROM users WHERE id = ?", id).Scan(&previous)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec("UPDATE users SET "+column+" = ? WHERE id = ?", fileName, id)
+	if err != nil {
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return "", err
+	}
+	return previous, nil
+}
+
+func UpdatePassword(id int, password string) error {
+	statement, err := db.Prepare("UPDATE users SET password = ? WHERE id = ?")
+
+	if err != nil {
+		return err
+	}
+
 	defer statement.Close()
 
-	result, err := statement.Exec(values...)
+	_, err = statement.Exec(util.Encrypt(password), id)
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	num, err := result.LastInsertId()
+	return nil
+}
+
+func SetEmailVerified(id int, verified bool) error {
+	statement, err := db.Prepare("UPDATE users SET email_verified = ? WHERE id = ?")
+
+	if err != nil {
+		return err
+	}
+
+	defer statement.Close()
+
+	_, err = statement.Exec(verified, id)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GetUserByEmail(email string) (*types.User, error) {
+
+	query := `
+	SELECT ` + userColumns + ` FROM users
+	WHERE
+	email = ?
+	LIMIT 1
+	`
+	rows, err := db.Query(query, strings.ToLower(strings.TrimSpace(email)))
 
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	var user *types.User = nil
+	for rows.Next() {
+		user, err = scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
 
-	return &num, nil
+// Looks user up by email, then by nick name (the same way signin does)
+func GetUserByEmailOrNickName(name string) (*types.User, error) {
+	user, err := GetUserByEmail(name)
+	if err != nil || user != nil {
+		return user, err
+	}
+
+	query := `
+	SELECT ` + userColumns + ` FROM users
+	WHERE
+	nick_name = ?
+	LIMIT 1
+	`
+	rows, err := db.Query(query, strings.TrimSpace(name))
+
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		user, err = scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 func GetUserById(id int) (*types.User, error) {
 
 	query := `
-	SELECT * FROM users
+	SELECT ` + userColumns + ` FROM users
 	WHERE
 	id = ?		
 	LIMIT 1
@@ -203,19 +372,7 @@ func GetUserById(id int) (*types.User, error) {
 	defer rows.Close()
 	var user *types.User = nil
 	for rows.Next() {
-		user = &types.User{}
-		err = rows.Scan(
-			&(user.Id),
-			&(user.FirstName),
-			&(user.LastName),
-			&(user.DateOfBirth),
-			&(user.NickName),
-			&(user.Email),
-			&(user.Password),
-			&(user.AboutMe),
-			&(user.Avatar),
-			&(user.Privacy))
-
+		user, err = scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -227,46 +384,93 @@ func GetUserById(id int) (*types.User, error) {
 	return user, nil
 }
 
-func GetUsers(currentUserId int) (*[]interface{}, error) {
-	users := []interface{}{}
-
-	query := `
-	SELECT id, nick_name, first_name, last_name, avatar FROM users`
+// Display name as shown in the client: nick name if set, otherwise first and last name
+const displayNameSql = "CASE WHEN COALESCE(users.nick_name, '') != '' THEN users.nick_name ELSE users.first_name || ' ' || users.last_name END"
+
+// Users matching query.Text by nick name, first or last name. Text with "@" matches email exactly
+// if the email is visible to the viewer.
+// Sorted by display name (case insensitive) or newest first. Accounts scheduled for deletion
+// and users who blocked the viewer are skipped
+func SearchUsers(viewerId int, query types.UserQuery) ([]types.UserSearchResult, error) {
+
+	//Follow status columns first, then conditions
+	args := []interface{}{viewerId, viewerId, viewerId}
+	conditions := []string{"users.id != ?", "users.deletion_scheduled_at = 0", "users.id NOT IN (" + blockedBySql + ")"}
+	args = append(args, viewerId, viewerId)
+
+	text := strings.TrimSpace(query.Text)
+	if strings.Contains(text, "@") {
+		conditions = append(conditions, `users.email = ?
+			AND (users.email_visibility = 'public'
+			OR (users.email_visibility = 'followers' AND EXISTS (SELECT 1 FROM followers WHERE follower = ? AND followee = users.id AND approved = true)))`)
+		args = append(args, strings.ToLower(text), viewerId)
+	} else if text != "" {
+		pattern := "%" + escapeLike(strings.ToLower(text)) + "%"
+		conditions = append(conditions, `(LOWER(COALESCE(users.nick_name, '')) LIKE ? ESCAPE '\'
+			OR LOWER(users.first_name) LIKE ? ESCAPE '\'
+			OR LOWER(users.last_name) LIKE ? ESCAPE '\'
+			OR LOWER(users.first_name || ' ' || users.last_name) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern, pattern, pattern)
+	}
 
-	rows, err := db.Query(query)
+	order := "LOWER(" + displayNameSql + "), users.id"
+	if query.Sort == "newest" {
+		order = "users.id DESC"
+		if query.After != nil {
+			conditions = append(conditions, "users.id < ?")
+			args = append(args, query.After.Id)
+		}
+	} else if query.After != nil {
+		conditions = append(conditions, "(LOWER("+displayNameSql+") > ? OR (LOWER("+displayNameSql+") = ? AND users.id > ?))")
+		args = append(args, query.After.Value, query.After.Value, query.After.Id)
+	}
+	args = append(args, query.Limit)
+
+	statement := `
+	SELECT
+	users.id, ` + displayNameSql + `, LOWER(` + displayNameSql + `), COALESCE(users.avatar, ''),
+	EXISTS (SELECT 1 FROM followers WHERE follower = ? AND followee = users.id AND approved = true),
+	EXISTS (SELECT 1 FROM followers WHERE follower = ? AND followee = users.id AND approved = false),
+	EXISTS (SELECT 1 FROM followers WHERE follower = users.id AND followee = ? AND approved = true)
+	FROM users
+	WHERE
+	` + strings.Join(conditions, " AND ") + `
+	ORDER BY
+	` + order + `
+	LIMIT ?`
 
+	rows, err := db.Query(statement, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var nickName string
-	var firstName string
-	var lastName string
-
+	users := []types.UserSearchResult{}
 	for rows.Next() {
-		user := types.UserBasicInfo{}
-		err := rows.Scan(
+		user := types.UserSearchResult{}
+		err = rows.Scan(
 			&(user.Id),
-			&nickName,
-			&firstName,
-			&lastName,
+			&(user.DisplayName),
+			&(user.SortName),
 			&(user.Avatar),
-		)
-
+			&(user.Following),
+			&(user.Pending),
+			&(user.FollowsYou))
 		if err != nil {
 			return nil, err
 		}
-
-		if nickName != "" {
-			user.DisplayName = nickName
-		} else {
-			user.DisplayName = firstName + " " + lastName
-		}
-
 		users = append(users, user)
-
 	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
 
-	return &users, nil
+// Escapes LIKE wildcards. Use with ESCAPE '\'
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\\\")
+	s = strings.ReplaceAll(s, "%", "\\%")
+	return strings.ReplaceAll(s, "_", "\\_")
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	db "my-social-network/db/sqlite"
+	types "my-social-network/types"
+	util "my-social-network/util"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Creates export job and builds the archive in background
+func startExport(userId int) (*types.Job, error) {
+	now := util.GetCurrentMilli()
+	job := types.Job{
+		UserId:    userId,
+		Type:      JOB_TYPE_EXPORT,
+		Status:    JOB_STATUS_PENDING,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	id, err := db.SaveJob(job)
+	if err != nil {
+		return nil, err
+	}
+	job.Id = int(*id)
+
+	go runExport(job)
+	return &job, nil
+}
+
+func runExport(job types.Job) {
+	job.Status = JOB_STATUS_RUNNING
+	job.UpdatedAt = util.GetCurrentMilli()
+	err := db.UpdateJob(job)
+	if err != nil {
+		fmt.Println("Error: could not update job. ", err)
+	}
+
+	file, err := writeExportArchive(job)
+	job.UpdatedAt = util.GetCurrentMilli()
+	if err != nil {
+		fmt.Printf("Error: export %v failed. %v\n", job.Id, err)
+		job.Status = JOB_STATUS_FAILED
+		job.Error = err.Error()
+	} else {
+		job.Status = JOB_STATUS_READY
+		job.File = file
+		job.ExpiresAt = job.UpdatedAt + exportTTL.Milliseconds()
+	}
+
+	err = db.UpdateJob(job)
+	if err != nil {
+		fmt.Println("Error: could not update job. ", err)
+		return
+	}
+
+	wsMessage := types.WSMessage{
+		Type:    EXPORT_READY,
+		Payload: job,
+	}
+	b, err := json.Marshal(wsMessage)
+	if err == nil {
+		notifyClient(job.UserId, b)
+	}
+}
+
+// Writes ZIP with one JSON file per kind of data and uploaded images.
+// Returns file name inside EXPORTS_DIRECTORY
+func writeExportArchive(job types.Job) (string, error) {
+	user, err := db.GetUserById(job.UserId)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "", fmt.Errorf("user %v not found", job.UserId)
+	}
+
+	data, images, err := db.GetUserExportData(job.UserId)
+	if err != nil {
+		return "", err
+	}
+
+	err = makeDirectoryIfNotExists(EXPORTS_DIRECTORY)
+	if err != nil {
+		return "", err
+	}
+
+	token, err := generateSecureToken()
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("export-%v-%v-%v.zip", job.UserId, job.Id, token[:16])
+	tempFile, err := os.CreateTemp(EXPORTS_DIRECTORY, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tempName := tempFile.Name()
+	defer os.Remove(tempName)
+
+	archive := zip.NewWriter(tempFile)
+
+	writeJson := func(fileName string, v interface{}) error {
+		w, err := archive.Create(fileName)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	//Password has json:"-"
+	err = writeJson("profile.json", user)
+	if err == nil {
+		keys := []string{}
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			err = writeJson(key+".json", data[key])
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		for _, image := range images {
+			err = addFileToArchive(archive, filepath.Join(IMAGES_DIRECTORY, filepath.Base(image)), "images/"+filepath.Base(image))
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	closeErr := archive.Close()
+	fileCloseErr := tempFile.Close()
+	if err != nil {
+		return "", err
+	}
+	if closeErr != nil {
+		return "", closeErr
+	}
+	if fileCloseErr != nil {
+		return "", fileCloseErr
+	}
+
+	err = os.Rename(tempName, filepath.Join(EXPORTS_DIRECTORY, name))
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Missing images are skipped
+func addFileToArchive(archive *zip.Writer, path string, name string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// Periodically removes expired archives. Jobs interrupted by restart are marked as failed
+func sweepExports() {
+	err := db.FailUnfinishedJobs([]string{JOB_STATUS_PENDING, JOB_STATUS_RUNNING}, JOB_STATUS_FAILED, util.GetCurrentMilli())
+	if err != nil {
+		fmt.Println("Error: could not update unfinished jobs. ", err)
+	}
+
+	ticker := time.NewTicker(exportSweepInterval)
+	defer ticker.Stop()
+	for {
+		now := util.GetCurrentMilli()
+		jobs, err := db.GetExpiredJobs(JOB_STATUS_READY, now)
+		if err != nil {
+			fmt.Println("Error: could not get expired jobs. ", err)
+		} else {
+			for _, job := range *jobs {
+				removeExportFile(job.File)
+				job.Status = JOB_STATUS_EXPIRED
+				job.File = ""
+				job.UpdatedAt = now
+				err = db.UpdateJob(job)
+				if err != nil {
+					fmt.Println("Error: could not update job. ", err)
+				}
+			}
+		}
+		<-ticker.C
+	}
+}
+
+func removeExportFile(name string) {
+	if name == "" {
+		return
+	}
+	err := os.Remove(filepath.Join(EXPORTS_DIRECTORY, filepath.Base(name)))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error: could not delete export. ", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -19,6 +20,8 @@ import (
 	db "my-social-network/db/sqlite"
 
 	util "my-social-network/util"
+
+	"my-social-network/totp"
 )
 
 func rootHandler(w http.ResponseWriter, r *http.Request) {
@@ -40,22 +43,60 @@ func signinHandler(w http.ResponseWriter, r *http.Request) {
 			resp.Error = &types.Error{Type: INVALID_USER_FORMAT, Message: "Error: username should be between 2 and 50 characters long"}
 		} else {
 
+			account, err := db.GetUserByEmailOrNickName(user)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: cannot get user from database"}
+				sendResponse(w, resp)
+				return
+			}
+
+			accountId := 0
+			if account != nil {
+				accountId = account.Id
+			}
+			if e := checkLoginThrottle(accountId, getClientIp(r)); e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+
 			user, err := db.GetUserByEmailOrNickNameAndPassword(types.User{NickName: user, Password: password})
 
 			if err != nil {
 				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: cannot get user from database"}
 			} else if user == nil {
+				recordLoginFailure(account, r)
 				resp.Error = &types.Error{Type: NO_USER_FOUND, Message: "Error: no user found"}
 			} else {
 
-				sessionId := generateSessionId()
+				twoFactor, e := getEnabledTwoFactor(user.Id)
+				if e != nil {
+					resp.Error = e
+					sendResponse(w, resp)
+					return
+				}
+
+				//Second step is required, session is created by /signin/2fa
+				if twoFactor != nil {
+					challenge, err := createTwoFactorChallenge(user.Id)
+					if err != nil {
+						resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: cannot save two factor challenge"}
+					} else {
+						resp.Payload = challenge
+					}
+					sendResponse(w, resp)
+					return
+				}
+
+				recordLoginSuccess(user.Id, r)
 
-				err = db.SaveSession(user.Id, sessionId)
+				session, err := createSession(user.Id, r)
 
 				if err != nil {
 					resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: cannot save session"}
 				} else {
-					resp.Payload = types.Session{SessionId: sessionId}
+					setSessionCookie(w, session)
+					resp.Payload = types.Session{SessionId: session.SessionId}
 				}
 			}
 		}
@@ -63,6 +104,101 @@ func signinHandler(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, resp)
 }
 
+// Second signin step for accounts with 2FA. Takes challenge token returned by /signin
+// and TOTP or recovery code
+func signinTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	token := strings.TrimSpace(r.FormValue("challenge_token"))
+	code := strings.TrimSpace(r.FormValue("code"))
+	if token == "" || code == "" {
+		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing parameter: challenge_token or code"}
+		sendResponse(w, resp)
+		return
+	}
+
+	now := util.GetCurrentMilli()
+	challenge, err := db.GetTwoFactorChallenge(util.HashToken(token), now, TWO_FACTOR_CHALLENGE_MAX_ATTEMPTS)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get challenge from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if challenge == nil {
+		resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired challenge token"}
+		sendResponse(w, resp)
+		return
+	}
+
+	if e := checkLoginThrottle(challenge.UserId, getClientIp(r)); e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	twoFactor, e := getEnabledTwoFactor(challenge.UserId)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+	if twoFactor == nil {
+		resp.Error = &types.Error{Type: TWO_FACTOR_NOT_ENABLED, Message: "Error: two factor authentication is not enabled"}
+		sendResponse(w, resp)
+		return
+	}
+
+	valid, err := verifyTwoFactorCode(twoFactor, code)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not verify code. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if !valid {
+		err = db.IncrementTwoFactorChallengeAttempts(challenge.Id)
+		if err != nil {
+			fmt.Println("Error: could not update two factor challenge. ", err)
+		}
+		account, err := db.GetUserById(challenge.UserId)
+		if err == nil {
+			recordLoginFailure(account, r)
+		}
+		resp.Error = &types.Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: invalid code"}
+		sendResponse(w, resp)
+		return
+	}
+
+	num, err := db.UseTwoFactorChallenge(challenge.Id, now)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update challenge in database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if *num == 0 {
+		resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired challenge token"}
+		sendResponse(w, resp)
+		return
+	}
+
+	recordLoginSuccess(challenge.UserId, r)
+
+	session, err := createSession(challenge.UserId, r)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: cannot save session"}
+		sendResponse(w, resp)
+		return
+	}
+	setSessionCookie(w, session)
+	resp.Payload = types.Session{SessionId: session.SessionId}
+	sendResponse(w, resp)
+}
+
 func signupHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := types.Response{Payload: nil, Error: nil}
@@ -86,107 +222,30 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		password := strings.TrimSpace(r.FormValue("password"))
 		about := strings.TrimSpace(r.FormValue("about"))
 
-		//Validate image
-
-		fileName := ""
-
-		file, _, err := r.FormFile("image")
-
-		if err == nil {
-
-			defer file.Close()
-
-			err = makeDirectoryIfNotExists(IMAGES_DIRECTORY)
-
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while creating directory %v", err)}
-				sendResponse(w, resp)
-				return
-			}
-
-			uuid := generateSessionId()
-			tempFile, err := os.CreateTemp(IMAGES_DIRECTORY, fmt.Sprintf("%v-*.gif", uuid))
-
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while creating temp file %v", err)}
-				sendResponse(w, resp)
-				return
-			}
-
-			defer tempFile.Close()
-
-			fileBytes, err := io.ReadAll(file)
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while reading file %v", err)}
-				sendResponse(w, resp)
-				return
-			}
-
-			_, err = tempFile.Write(fileBytes)
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while writing file %v", err)}
-				sendResponse(w, resp)
-				return
-			}
-
-			fileName = filepath.Base(tempFile.Name())
-
-		} else {
-			if !strings.Contains(err.Error(), "no such file") {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: image error %v", err)}
-				sendResponse(w, resp)
-				return
-			}
-		}
-
 		//Validate input
-		if len(firstName) < 1 || len(firstName) > 50 {
-			resp.Error = &types.Error{Type: INVALID_FIRST_NAME_FORMAT, Message: "Error: First Name should be between 1 and 50 characters long"}
-			sendResponse(w, resp)
-			return
-		}
-		if len(lastName) < 1 || len(lastName) > 50 {
-			resp.Error = &types.Error{Type: INVALID_LAST_NAME_FORMAT, Message: "Error: Last Name should be between 1 and 50 characters long"}
-			sendResponse(w, resp)
-			return
-		}
-		if len(nickName) != 0 && (len(nickName) < 2 || len(nickName) > 50) {
-			resp.Error = &types.Error{Type: INVALID_NICK_NAME_FORMAT, Message: "Error: Nickname should be between 2 and 50 characters long"}
-			sendResponse(w, resp)
-			return
-		}
-
-		parseTime, err := time.Parse("2006-01-02 15:04:05", dateOfBirth+" 00:00:00")
-		if err != nil {
-			resp.Error = &types.Error{Type: INVALID_DATE_FORMAT, Message: "Error: Invalid date format"}
-			sendResponse(w, resp)
-			return
-		}
-		milli := parseTime.UnixNano() / 1000000
-		unixMilli := time.Now().UnixMilli()
-
-		if milli > unixMilli {
-			resp.Error = &types.Error{Type: INVALID_DATE_FORMAT, Message: "Error: Invalid date"}
+		milli, e := validateSignupDetails(firstName, lastName, nickName, dateOfBirth, email)
+		if e != nil {
+			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
 
-		reg := `^[^@\s]+@[^@\s]+\.[^@\s]+$`
-		match, err := regexp.MatchString(reg, email)
-		if err != nil || !match {
-			resp.Error = &types.Error{Type: INVALID_EMAIL, Message: "Error: invalid email"}
+		if e := validatePassword(password); e != nil {
+			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
 
-		if len(password) < 6 || len(password) > 50 {
-			resp.Error = &types.Error{Type: INVALID_PASSWORD, Message: "Error: password should be between 6 and 50 charachters long"}
+		if e := validateAboutMe(about); e != nil {
+			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
 
-		if len(about) > 5000 {
-			resp.Error = &types.Error{Type: INVALID_ABOUT_ME, Message: "Error: about me should be less than 5000 charachters"}
+		//Store image after other input is valid
+		fileName, e := saveUploadedImage(r, "image")
+		if e != nil {
+			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
@@ -207,6 +266,7 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		)
 
 		if err != nil {
+			removeImage(fileName)
 
 			errorStr := fmt.Sprintf("%v", err)
 
@@ -223,246 +283,2104 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		//Generate session id
-		sessionId := generateSessionId()
+		//Send verification email
+		err = sendVerificationEmail(&types.User{Id: int(id), Email: strings.ToLower(email)})
+		if err != nil {
+			fmt.Println("Error: could not send verification email. ", err)
+		}
 
-		//Save session id to db
-		err = db.SaveSession(int(id), sessionId)
+		//Generate and save session
+		session, err := createSession(int(id), r)
 
 		if err != nil {
 			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: cannot save session"}
+		} else {
+			setSessionCookie(w, session)
+			resp.Payload = types.Session{SessionId: session.SessionId}
 		}
 
-		resp.Payload = types.Session{SessionId: sessionId}
-
 	}
 	sendResponse(w, resp)
 }
 
+// POST only: the session cookie is sent on cross-site navigations, which must not sign the user out
 func signoutHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := types.Response{Payload: nil, Error: nil}
 
-	if r.Method != "GET" {
+	if r.Method != "POST" {
 		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
 		sendResponse(w, resp)
 		return
 	}
 
-	keys, ok := r.URL.Query()["session_id"]
-	if !ok || len(keys[0]) < 1 {
-		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing request parameter: session_id"}
+	user, session, e := getUserAndSessionFromRequest(r)
+	if e != nil {
+		resp.Error = e
 		sendResponse(w, resp)
 		return
 	}
-	session_id := keys[0]
 
-	user, err := db.GetUserBySessionId(session_id)
-
-	if err != nil || user == nil {
-		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+	err := db.DeleteSession(session.SessionId)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete session from database. %v", err)}
 		sendResponse(w, resp)
 		return
 	}
-	removeClient(user.Id)
+	removeSessionClient(user.Id, session.SessionId)
+	clearSessionCookie(w)
 
-	user.Password = ""
-	user.DateOfBirth = 0
-	resp.Payload = user
 	resp.Payload = user.Id
 	sendResponse(w, resp)
 }
 
-func sendResponse(w http.ResponseWriter, resp types.Response) {
-	w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
-	json.NewEncoder(w).Encode(resp)
-}
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
 
-func imageHandler(w http.ResponseWriter, r *http.Request) {
+	user, currentSession, e := getUserAndSessionFromRequest(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
 
-	resp := types.Response{Payload: nil, Error: nil}
+	//Extract session id
+	idStr := ""
+	if strings.Contains(r.URL.Path, "/sessions/") {
+		idStr = strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/sessions/"))
+	}
 
 	if r.Method == "GET" {
-		keys, ok := r.URL.Query()["id"]
-		if !ok || len(keys[0]) < 1 {
-			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing request image parameter: id"}
-			json.NewEncoder(w).Encode(resp)
+
+		sessions, err := db.GetActiveSessionsByUserId(user.Id, util.GetCurrentMilli())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get sessions from database. %v", err)}
+			sendResponse(w, resp)
 			return
 		}
-		id := keys[0]
-		path := "images/" + id
-		w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
-		http.ServeFile(w, r, path)
+		for index, session := range *sessions {
+			(*sessions)[index].Current = session.Id == currentSession.Id
+		}
 
-	} else {
-		sendResponse(w, resp)
-	}
-}
+		resp.Payload = sessions
 
-func userHandler(w http.ResponseWriter, r *http.Request) {
-	resp := types.Response{Payload: nil, Error: nil}
+	} else if r.Method == "DELETE" {
 
-	if r.Method == "GET" {
-		user, err := getUserFromRequest(r)
-		if err != nil {
-			resp.Error = err
-		} else {
+		if idStr != "" {
+			//Revoke one session
+			id, err := strconv.Atoi(idStr)
+			if err != nil || id < 1 {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", idStr)}
+				sendResponse(w, resp)
+				return
+			}
 
-			person_id_str := ""
-			if strings.Contains(r.URL.Path, "/user/") {
-				person_id_str = strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/user/"))
+			sessions, err := db.GetActiveSessionsByUserId(user.Id, util.GetCurrentMilli())
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get sessions from database. %v", err)}
+				sendResponse(w, resp)
+				return
 			}
 
-			if person_id_str != "" {
+			num, err := db.RevokeSession(user.Id, id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not revoke session. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			if *num == 0 {
+				resp.Error = &types.Error{Type: SESSION_NOT_FOUND, Message: fmt.Sprintf("Error: no active session found with id: %v", id)}
+				sendResponse(w, resp)
+				return
+			}
 
-				person_id, err := strconv.Atoi(person_id_str)
-				if err != nil {
-					resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", person_id_str)}
-					sendResponse(w, resp)
-					return
-				}
-				person, err := db.GetUserById(person_id)
-				if err != nil {
-					resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
-					sendResponse(w, resp)
-					return
+			for _, session := range *sessions {
+				if session.Id == id {
+					removeSessionClient(user.Id, session.SessionId)
 				}
+			}
+			if id == currentSession.Id {
+				clearSessionCookie(w)
+			}
 
-				//Check Privacy
-				e := isAccessRestricted(user.Id, person_id)
-				if e == nil {
-					resp.Payload = person
-				} else {
-					if e.Type == AUTHORIZATION {
-						userRestricted := types.User{}
-						userRestricted.Id = person.Id
-						userRestricted.Avatar = person.Avatar
-						if person.NickName != "" {
-							userRestricted.NickName = person.NickName
-						} else {
-							userRestricted.FirstName = person.FirstName
-							userRestricted.LastName = person.LastName
-						}
-						resp.Payload = userRestricted
+			resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+
+		} else {
+			//Revoke all sessions. Current one can be kept with except_current=true
+			exceptSessionId := ""
+			params, ok := r.URL.Query()["except_current"]
+			if ok && strings.TrimSpace(params[0]) == "true" {
+				exceptSessionId = currentSession.SessionId
+			}
+
+			num, err := revokeUserSessions(user.Id, exceptSessionId)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not revoke sessions. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			if exceptSessionId == "" {
+				clearSessionCookie(w)
+			}
+
+			resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+		}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+func passwordHandler(w http.ResponseWriter, r *http.Request) {
+
+	if strings.Contains(r.URL.Path, "/password/forgot") {
+		forgotPasswordHandler(w, r)
+		return
+	}
+
+	if strings.Contains(r.URL.Path, "/password/reset") {
+		resetPasswordHandler(w, r)
+		return
+	}
+
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	user, session, e := getUserAndSessionFromRequest(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	oldPassword := strings.TrimSpace(r.FormValue("old_password"))
+	newPassword := strings.TrimSpace(r.FormValue("new_password"))
+
+	if !util.CompairPasswords(user.Password, oldPassword) {
+		resp.Error = &types.Error{Type: INVALID_PASSWORD, Message: "Error: old password is incorrect"}
+		sendResponse(w, resp)
+		return
+	}
+
+	if e := validatePassword(newPassword); e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	err := db.UpdatePassword(user.Id, newPassword)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update password in database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+
+	//Sign out other devices
+	_, err = revokeUserSessions(user.Id, session.SessionId)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not revoke sessions. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+
+	resp.Payload = types.Updated{Updated: 1}
+	sendResponse(w, resp)
+}
+
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	email := strings.TrimSpace(r.FormValue("email"))
+	if email == "" {
+		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing parameter: email"}
+		sendResponse(w, resp)
+		return
+	}
+
+	user, err := db.GetUserByEmail(email)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+		sendResponse(w, resp)
+		return
+	}
+
+	//Same response whether email is registered or not
+	if user != nil {
+		token, err := generateSecureToken()
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not generate token. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		now := util.GetCurrentMilli()
+		_, err = db.SavePasswordResetToken(user.Id, util.HashToken(token), now, now+passwordResetTokenTTL.Milliseconds())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save token to database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		body := fmt.Sprintf("Someone requested a password reset for your account.\n\n"+
+			"Follow the link to set a new password: %v/reset-password?token=%v\n\n"+
+			"The link is valid for %v. If you did not request it, ignore this email.", appUrl, token, passwordResetTokenTTL)
+		sendMail(user.Email, "Password reset", body)
+	}
+
+	resp.Payload = types.Inserted{Inserted: 1}
+	sendResponse(w, resp)
+}
+
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	tokenStr := strings.TrimSpace(r.FormValue("token"))
+	newPassword := strings.TrimSpace(r.FormValue("new_password"))
+
+	if tokenStr == "" {
+		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing parameter: token"}
+		sendResponse(w, resp)
+		return
+	}
+
+	if e := validatePassword(newPassword); e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	token, err := db.GetPasswordResetToken(util.HashToken(tokenStr))
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get token from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if token == nil {
+		resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired token"}
+		sendResponse(w, resp)
+		return
+	}
+
+	//Single use
+	num, err := db.UsePasswordResetToken(token.Id, util.GetCurrentMilli())
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update token in database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if *num == 0 {
+		resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired token"}
+		sendResponse(w, resp)
+		return
+	}
+
+	err = db.UpdatePassword(token.UserId, newPassword)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update password in database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+
+	err = db.DeletePasswordResetTokensByUserId(token.UserId)
+	if err != nil {
+		fmt.Println("Error: could not delete password reset tokens. ", err)
+	}
+
+	//Sign out everywhere
+	_, err = revokeUserSessions(token.UserId, "")
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not revoke sessions. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+
+	resp.Payload = types.Updated{Updated: 1}
+	sendResponse(w, resp)
+}
+
+// Signup rules for user details. Shared by signup form and external sign in
+// provisioning. Returns date of birth in milliseconds
+func validateSignupDetails(firstName string, lastName string, nickName string, dateOfBirth string, email string) (int64, *types.Error) {
+	if e := validateFirstName(firstName); e != nil {
+		return 0, e
+	}
+	if e := validateLastName(lastName); e != nil {
+		return 0, e
+	}
+	if e := validateNickName(nickName); e != nil {
+		return 0, e
+	}
+	milli, e := parseDateOfBirth(dateOfBirth)
+	if e != nil {
+		return 0, e
+	}
+	if e := validateEmail(email); e != nil {
+		return 0, e
+	}
+	return milli, nil
+}
+
+func validateFirstName(firstName string) *types.Error {
+	if len(firstName) < 1 || len(firstName) > 50 {
+		return &types.Error{Type: INVALID_FIRST_NAME_FORMAT, Message: "Error: First Name should be between 1 and 50 characters long"}
+	}
+	return nil
+}
+
+func validateLastName(lastName string) *types.Error {
+	if len(lastName) < 1 || len(lastName) > 50 {
+		return &types.Error{Type: INVALID_LAST_NAME_FORMAT, Message: "Error: Last Name should be between 1 and 50 characters long"}
+	}
+	return nil
+}
+
+// Nickname is optional
+func validateNickName(nickName string) *types.Error {
+	if len(nickName) != 0 && (len(nickName) < 2 || len(nickName) > 50) {
+		return &types.Error{Type: INVALID_NICK_NAME_FORMAT, Message: "Error: Nickname should be between 2 and 50 characters long"}
+	}
+	return nil
+}
+
+// Parses YYYY-MM-DD into milliseconds. Date can not be in future
+func parseDateOfBirth(dateOfBirth string) (int64, *types.Error) {
+	parseTime, err := time.Parse("2006-01-02 15:04:05", dateOfBirth+" 00:00:00")
+	if err != nil {
+		return 0, &types.Error{Type: INVALID_DATE_FORMAT, Message: "Error: Invalid date format"}
+	}
+	milli := parseTime.UnixNano() / 1000000
+	unixMilli := time.Now().UnixMilli()
+
+	if milli > unixMilli {
+		return 0, &types.Error{Type: INVALID_DATE_FORMAT, Message: "Error: Invalid date"}
+	}
+	return milli, nil
+}
+
+func validateEmail(email string) *types.Error {
+	reg := `^[^@\s]+@[^@\s]+\.[^@\s]+$`
+	match, err := regexp.MatchString(reg, email)
+	if err != nil || !match {
+		return &types.Error{Type: INVALID_EMAIL, Message: "Error: invalid email"}
+	}
+	return nil
+}
+
+func validateAboutMe(about string) *types.Error {
+	if len(about) > 5000 {
+		return &types.Error{Type: INVALID_ABOUT_ME, Message: "Error: about me should be less than 5000 charachters"}
+	}
+	return nil
+}
+
+// Visibility of a profile field: public, followers or private
+func validateVisibility(field string, visibility string) *types.Error {
+	if visibility != VISIBILITY_PUBLIC && visibility != VISIBILITY_FOLLOWERS && visibility != VISIBILITY_PRIVATE {
+		return &types.Error{Type: INVALID_VISIBILITY, Message: fmt.Sprintf("Error: %v should be public, followers or private", field)}
+	}
+	return nil
+}
+
+func validatePassword(password string) *types.Error {
+	if len(password) < 6 || len(password) > 50 {
+		return &types.Error{Type: INVALID_PASSWORD, Message: "Error: password should be between 6 and 50 charachters long"}
+	}
+	return nil
+}
+
+func emailHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	if strings.Contains(r.URL.Path, "/email/resend") {
+
+		user, e := getUserFromRequest(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		if user.EmailVerified {
+			resp.Error = &types.Error{Type: EMAIL_ALREADY_VERIFIED, Message: "Error: email is already verified"}
+			sendResponse(w, resp)
+			return
+		}
+
+		err := sendVerificationEmail(user)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not create verification token. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.Inserted{Inserted: 1}
+
+	} else if strings.Contains(r.URL.Path, "/email/verify") {
+
+		tokenStr := strings.TrimSpace(r.FormValue("token"))
+		if tokenStr == "" {
+			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing parameter: token"}
+			sendResponse(w, resp)
+			return
+		}
+
+		token, err := db.GetEmailVerificationToken(util.HashToken(tokenStr))
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get token from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if token == nil {
+			resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired token"}
+			sendResponse(w, resp)
+			return
+		}
+
+		user, err := db.GetUserById(token.UserId)
+		if err != nil || user == nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Token was issued for an address the user no longer has
+		if user.Email != token.Email {
+			resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired token"}
+			sendResponse(w, resp)
+			return
+		}
+
+		num, err := db.UseEmailVerificationToken(token.Id, util.GetCurrentMilli())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update token in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if *num == 0 {
+			resp.Error = &types.Error{Type: INVALID_TOKEN, Message: "Error: invalid or expired token"}
+			sendResponse(w, resp)
+			return
+		}
+
+		err = db.SetEmailVerified(user.Id, true)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update user in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		err = db.DeleteEmailVerificationTokensByUserId(user.Id)
+		if err != nil {
+			fmt.Println("Error: could not delete email verification tokens. ", err)
+		}
+
+		resp.Payload = types.Updated{Updated: 1}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: unknown endpoint"}
+	}
+
+	sendResponse(w, resp)
+}
+
+// Recent security related events of the account (failed signins, lockouts)
+func securityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	user, e := getUserFromRequest(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	events, err := db.GetSecurityEventsByUserId(user.Id, SECURITY_EVENTS_LIMIT)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get security events from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+
+	resp.Payload = events
+	sendResponse(w, resp)
+}
+
+// Two-factor settings of the current user:
+// GET /2fa status, POST /2fa/enroll, /2fa/enable, /2fa/disable, /2fa/recovery-codes
+func twoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequest(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	twoFactor, err := db.GetTwoFactor(user.Id)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get two factor settings from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	enabled := twoFactor != nil && twoFactor.Enabled
+
+	if r.Method == "GET" {
+
+		status := types.TwoFactorStatus{Enabled: enabled}
+		if enabled {
+			codes, err := db.GetUnusedRecoveryCodes(user.Id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get recovery codes from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			status.RecoveryCodesLeft = len(*codes)
+		}
+		resp.Payload = status
+
+	} else if r.Method != "POST" {
+
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+
+	} else if strings.Contains(r.URL.Path, "/2fa/enroll") {
+
+		if enabled {
+			resp.Error = &types.Error{Type: TWO_FACTOR_ALREADY_ENABLED, Message: "Error: two factor authentication is already enabled"}
+			sendResponse(w, resp)
+			return
+		}
+
+		if !util.CompairPasswords(user.Password, r.FormValue("password")) {
+			resp.Error = &types.Error{Type: INVALID_PASSWORD, Message: "Error: wrong password"}
+			sendResponse(w, resp)
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not generate secret. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		err = db.SaveTwoFactorSecret(user.Id, secret, util.GetCurrentMilli())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save secret in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		resp.Payload = types.TwoFactorEnrollment{Secret: secret, OtpauthUri: totp.URI(totpIssuer, user.Email, secret)}
+
+	} else if strings.Contains(r.URL.Path, "/2fa/enable") {
+
+		if enabled {
+			resp.Error = &types.Error{Type: TWO_FACTOR_ALREADY_ENABLED, Message: "Error: two factor authentication is already enabled"}
+			sendResponse(w, resp)
+			return
+		}
+		if twoFactor == nil {
+			resp.Error = &types.Error{Type: TWO_FACTOR_NOT_ENROLLED, Message: "Error: start enrollment first"}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Proves that authenticator app has the secret
+		valid, err := verifyTwoFactorCode(twoFactor, r.FormValue("code"))
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not verify code. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if !valid {
+			resp.Error = &types.Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: invalid code"}
+			sendResponse(w, resp)
+			return
+		}
+
+		codes, err := generateRecoveryCodes(user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save recovery codes in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		err = db.EnableTwoFactor(user.Id, util.GetCurrentMilli())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update two factor settings in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		resp.Payload = types.RecoveryCodes{RecoveryCodes: codes}
+
+	} else if strings.Contains(r.URL.Path, "/2fa/disable") || strings.Contains(r.URL.Path, "/2fa/recovery-codes") {
+
+		if !enabled {
+			resp.Error = &types.Error{Type: TWO_FACTOR_NOT_ENABLED, Message: "Error: two factor authentication is not enabled"}
+			sendResponse(w, resp)
+			return
+		}
+
+		valid, err := verifyTwoFactorCode(twoFactor, r.FormValue("code"))
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not verify code. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if !valid {
+			resp.Error = &types.Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: invalid code"}
+			sendResponse(w, resp)
+			return
+		}
+
+		if strings.Contains(r.URL.Path, "/2fa/disable") {
+
+			if !util.CompairPasswords(user.Password, r.FormValue("password")) {
+				resp.Error = &types.Error{Type: INVALID_PASSWORD, Message: "Error: wrong password"}
+				sendResponse(w, resp)
+				return
+			}
+
+			err = db.DeleteTwoFactor(user.Id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete two factor settings from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = types.Updated{Updated: 1}
+
+		} else {
+
+			codes, err := generateRecoveryCodes(user.Id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save recovery codes in database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = types.RecoveryCodes{RecoveryCodes: codes}
+		}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: unknown endpoint"}
+	}
+
+	sendResponse(w, resp)
+}
+
+// External sign in:
+// GET /oidc/providers, GET /oidc/login/{provider}, POST /oidc/link/{provider},
+// GET /oidc/callback/{provider}, GET /oidc/identities, DELETE /oidc/identities/{id}
+func oidcHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if strings.HasPrefix(r.URL.Path, "/oidc/callback/") {
+		oidcCallback(w, r, strings.TrimPrefix(r.URL.Path, "/oidc/callback/"))
+		return
+	}
+
+	if r.Method == "GET" && r.URL.Path == "/oidc/providers" {
+
+		resp.Payload = getOidcProviderNames()
+
+	} else if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/oidc/login/") {
+
+		provider, ok := oidcProviders[strings.TrimPrefix(r.URL.Path, "/oidc/login/")]
+		if !ok {
+			resp.Error = &types.Error{Type: OIDC_PROVIDER_NOT_FOUND, Message: "Error: unknown provider"}
+			sendResponse(w, resp)
+			return
+		}
+
+		authorizationUrl, err := startOidcFlow(w, provider, 0)
+		if err != nil {
+			resp.Error = &types.Error{Type: OIDC_ERROR, Message: fmt.Sprintf("Error: could not start sign in. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		http.Redirect(w, r, authorizationUrl, http.StatusFound)
+		return
+
+	} else if r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/oidc/link/") {
+
+		user, e := getUserFromRequest(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		provider, ok := oidcProviders[strings.TrimPrefix(r.URL.Path, "/oidc/link/")]
+		if !ok {
+			resp.Error = &types.Error{Type: OIDC_PROVIDER_NOT_FOUND, Message: "Error: unknown provider"}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Client sends the browser to this url
+		authorizationUrl, err := startOidcFlow(w, provider, user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: OIDC_ERROR, Message: fmt.Sprintf("Error: could not start linking. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.AuthorizationUrl{AuthorizationUrl: authorizationUrl}
+
+	} else if strings.HasPrefix(r.URL.Path, "/oidc/identities") {
+
+		user, e := getUserFromRequest(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		identities, err := db.GetExternalIdentitiesByUserId(user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get identities from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		if r.Method == "GET" {
+
+			resp.Payload = identities
+
+		} else if r.Method == "DELETE" {
+
+			idStr := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/oidc/identities/"))
+			id, err := strconv.Atoi(idStr)
+			if err != nil || id < 1 {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", idStr)}
+				sendResponse(w, resp)
+				return
+			}
+
+			//Account without password must keep at least one way to sign in
+			if user.Password == "" && len(*identities) <= 1 {
+				resp.Error = &types.Error{Type: LAST_SIGNIN_METHOD, Message: "Error: set a password before unlinking the last provider"}
+				sendResponse(w, resp)
+				return
+			}
+
+			num, err := db.DeleteExternalIdentity(user.Id, id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete identity from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			if *num == 0 {
+				resp.Error = &types.Error{Type: IDENTITY_NOT_FOUND, Message: "Error: identity not found"}
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+
+		} else {
+			resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: unknown endpoint"}
+	}
+
+	sendResponse(w, resp)
+}
+
+// Provider redirects the browser here. Result is passed to the client by redirecting
+// to APP_URL: session cookie is set on success, errors go to ?error=<type>
+func oidcCallback(w http.ResponseWriter, r *http.Request, providerName string) {
+
+	redirectError := func(path string, errorType string) {
+		http.Redirect(w, r, appUrl+path+"?error="+url.QueryEscape(errorType), http.StatusFound)
+	}
+
+	provider, ok := oidcProviders[providerName]
+	if !ok {
+		redirectError("/signin", OIDC_PROVIDER_NOT_FOUND)
+		return
+	}
+
+	query := r.URL.Query()
+	state, err := db.TakeOidcState(util.HashToken(query.Get("state")), util.GetCurrentMilli())
+	if err != nil {
+		redirectError("/signin", DATABASE_ERROR)
+		return
+	}
+	setOidcBrowserCookie(w, "", -1)
+	if state == nil || state.Provider != provider.Name || !isOidcStateBrowser(r, state) {
+		redirectError("/signin", INVALID_TOKEN)
+		return
+	}
+
+	errorPath := "/signin"
+	if state.UserId > 0 {
+		errorPath = "/settings"
+
+		//Linking is finished by the user who started it
+		user, e := getUserFromRequest(r)
+		if e != nil || user.Id != state.UserId {
+			redirectError(errorPath, AUTHORIZATION)
+			return
+		}
+	}
+
+	if query.Get("error") != "" {
+		redirectError(errorPath, OIDC_ERROR)
+		return
+	}
+
+	claims, err := provider.Exchange(query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		fmt.Println("Error: oidc code exchange failed. ", err)
+		redirectError(errorPath, OIDC_ERROR)
+		return
+	}
+
+	identity, err := db.GetExternalIdentity(provider.Name, claims.Subject)
+	if err != nil {
+		redirectError(errorPath, DATABASE_ERROR)
+		return
+	}
+
+	//Linking to signed in user
+	if state.UserId > 0 {
+		if identity != nil && identity.UserId != state.UserId {
+			redirectError(errorPath, IDENTITY_ALREADY_LINKED)
+			return
+		}
+		if identity == nil {
+			_, err = db.SaveExternalIdentity(types.ExternalIdentity{
+				UserId:    state.UserId,
+				Provider:  provider.Name,
+				Subject:   claims.Subject,
+				Email:     strings.ToLower(claims.Email),
+				CreatedAt: util.GetCurrentMilli(),
+			})
+			if err != nil {
+				redirectError(errorPath, DATABASE_ERROR)
+				return
+			}
+		}
+		http.Redirect(w, r, appUrl+"/settings?linked="+url.QueryEscape(provider.Name), http.StatusFound)
+		return
+	}
+
+	var user *types.User
+	if identity != nil {
+		user, err = db.GetUserById(identity.UserId)
+		if err != nil || user == nil {
+			redirectError(errorPath, NO_USER_FOUND)
+			return
+		}
+	} else {
+		//First sign in
+		var e *types.Error
+		user, e = provisionOidcUser(claims)
+		if e != nil {
+			redirectError(errorPath, e.Type)
+			return
+		}
+		_, err = db.SaveExternalIdentity(types.ExternalIdentity{
+			UserId:    user.Id,
+			Provider:  provider.Name,
+			Subject:   claims.Subject,
+			Email:     user.Email,
+			CreatedAt: util.GetCurrentMilli(),
+		})
+		if err != nil {
+			redirectError(errorPath, DATABASE_ERROR)
+			return
+		}
+	}
+
+	twoFactor, e := getEnabledTwoFactor(user.Id)
+	if e != nil {
+		redirectError(errorPath, e.Type)
+		return
+	}
+	if twoFactor != nil {
+		challenge, err := createTwoFactorChallenge(user.Id)
+		if err != nil {
+			redirectError(errorPath, DATABASE_ERROR)
+			return
+		}
+		http.Redirect(w, r, appUrl+"/signin?challenge_token="+challenge.ChallengeToken, http.StatusFound)
+		return
+	}
+
+	recordLoginSuccess(user.Id, r)
+
+	session, err := createSession(user.Id, r)
+	if err != nil {
+		redirectError(errorPath, DATABASE_ERROR)
+		return
+	}
+	setSessionCookie(w, session)
+	http.Redirect(w, r, appUrl+"/", http.StatusFound)
+}
+
+// Personal API tokens of the current user: GET /tokens, POST /tokens, DELETE /tokens/{id}.
+// Tokens can not manage tokens, signed in session is required
+func apiTokensHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequest(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	if r.Method == "GET" {
+
+		tokens, err := db.GetApiTokensByUserId(user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get api tokens from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = tokens
+
+	} else if r.Method == "POST" {
+
+		name := strings.TrimSpace(r.FormValue("name"))
+		if len(name) < 1 || len(name) > 50 {
+			resp.Error = &types.Error{Type: INVALID_TOKEN_NAME, Message: "Error: name should be between 1 and 50 characters long"}
+			sendResponse(w, resp)
+			return
+		}
+
+		scopes := parseScopes(r.FormValue("scopes"))
+		if len(scopes) == 0 {
+			resp.Error = &types.Error{Type: INVALID_SCOPE, Message: fmt.Sprintf("Error: scopes should be a list of <resource>:read or <resource>:write, resources: %v", strings.Join(apiTokenResources, ", "))}
+			sendResponse(w, resp)
+			return
+		}
+
+		ttl := apiTokenDefaultTTL
+		if daysStr := strings.TrimSpace(r.FormValue("expires_in_days")); daysStr != "" {
+			days, err := strconv.Atoi(daysStr)
+			if err != nil || days < 1 || time.Duration(days)*24*time.Hour > apiTokenMaxTTL {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: expires_in_days should be between 1 and %v", int(apiTokenMaxTTL.Hours()/24))}
+				sendResponse(w, resp)
+				return
+			}
+			ttl = time.Duration(days) * 24 * time.Hour
+		}
+
+		secret, err := generateSecureToken()
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not generate token. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		tokenStr := API_TOKEN_PREFIX + secret
+
+		now := util.GetCurrentMilli()
+		token := types.ApiToken{
+			UserId:    user.Id,
+			Name:      name,
+			Scopes:    scopes,
+			CreatedAt: now,
+			ExpiresAt: now + ttl.Milliseconds(),
+		}
+		id, err := db.SaveApiToken(token, util.HashToken(tokenStr))
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save api token in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		token.Id = int(*id)
+
+		resp.Payload = types.CreatedApiToken{Token: tokenStr, ApiToken: &token}
+
+	} else if r.Method == "DELETE" {
+
+		idStr := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/tokens/"))
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", idStr)}
+			sendResponse(w, resp)
+			return
+		}
+
+		num, err := db.DeleteApiToken(user.Id, id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete api token from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if *num == 0 {
+			resp.Error = &types.Error{Type: API_TOKEN_NOT_FOUND, Message: "Error: api token not found"}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+// Personal data export: POST /export starts a job, GET /export lists jobs,
+// GET /export/{id} downloads the archive when it is ready
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequest(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	idStr := ""
+	if strings.Contains(r.URL.Path, "/export/") {
+		idStr = strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/export/"))
+	}
+
+	if r.Method == "POST" {
+
+		jobs, err := db.GetJobsByUserId(user.Id, JOB_TYPE_EXPORT)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get jobs from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		//One export at a time
+		for _, job := range *jobs {
+			if job.Status == JOB_STATUS_PENDING || job.Status == JOB_STATUS_RUNNING {
+				resp.Payload = job
+				sendResponse(w, resp)
+				return
+			}
+		}
+
+		job, err := startExport(user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save job in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = job
+
+	} else if r.Method == "GET" && idStr == "" {
+
+		jobs, err := db.GetJobsByUserId(user.Id, JOB_TYPE_EXPORT)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get jobs from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = jobs
+
+	} else if r.Method == "GET" {
+
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", idStr)}
+			sendResponse(w, resp)
+			return
+		}
+
+		job, err := db.GetJob(user.Id, id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get job from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if job == nil || job.Type != JOB_TYPE_EXPORT {
+			resp.Error = &types.Error{Type: JOB_NOT_FOUND, Message: "Error: export not found"}
+			sendResponse(w, resp)
+			return
+		}
+		if job.Status != JOB_STATUS_READY || job.ExpiresAt <= util.GetCurrentMilli() {
+			resp.Error = &types.Error{Type: EXPORT_NOT_READY, Message: fmt.Sprintf("Error: export is %v", job.Status)}
+			sendResponse(w, resp)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"my-data-%v.zip\"", job.Id))
+		http.ServeFile(w, r, filepath.Join(EXPORTS_DIRECTORY, filepath.Base(job.File)))
+		return
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+func sendResponse(w http.ResponseWriter, resp types.Response) {
+	w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Allow-Credentials", "true")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func imageHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method == "GET" {
+		keys, ok := r.URL.Query()["id"]
+		if !ok || len(keys[0]) < 1 {
+			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing request image parameter: id"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		id := keys[0]
+		path := "images/" + id
+		w.Header().Set("Access-Control-Allow-Origin", clientOrigin)
+		http.ServeFile(w, r, path)
+
+	} else {
+		sendResponse(w, resp)
+	}
+}
+
+func userHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method == "GET" {
+		user, err := getUserFromRequestWithScope(r, SCOPE_PROFILE)
+		if err != nil {
+			resp.Error = err
+		} else {
+
+			person_id_str := ""
+			if strings.Contains(r.URL.Path, "/user/") {
+				person_id_str = strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/user/"))
+			}
+
+			if person_id_str != "" {
+
+				person_id, err := strconv.Atoi(person_id_str)
+				if err != nil {
+					resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", person_id_str)}
+					sendResponse(w, resp)
+					return
+				}
+				person, err := db.GetUserById(person_id)
+				if err != nil {
+					resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get user from database"}
+					sendResponse(w, resp)
+					return
+				}
+
+				if person == nil {
+					resp.Error = &types.Error{Type: NO_USER_FOUND, Message: "Error: user not found"}
+					sendResponse(w, resp)
+					return
+				}
+
+				//Check Privacy
+				e := isAccessRestricted(user.Id, person_id)
+				if e == nil {
+					e = applyFieldVisibility(user.Id, person)
+				}
+				if e == nil {
+					resp.Payload = person
+				} else {
+					if e.Type == AUTHORIZATION {
+						userRestricted := types.User{}
+						userRestricted.Id = person.Id
+						userRestricted.Avatar = person.Avatar
+						if person.NickName != "" {
+							userRestricted.NickName = person.NickName
+						} else {
+							userRestricted.FirstName = person.FirstName
+							userRestricted.LastName = person.LastName
+						}
+						resp.Payload = userRestricted
 					} else {
 						resp.Error = e
 					}
 				}
 
-			} else {
-				resp.Payload = user
+			} else {
+				resp.Payload = user
+			}
+
+		}
+
+	} else if r.Method == "PATCH" {
+		user, e := getUserFromRequestWithScope(r, SCOPE_PROFILE)
+
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		err := r.ParseForm()
+		if err != nil {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse form: %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Email and privacy are security settings. Api tokens can not change them, signed in session is required
+		_, hasEmail := r.PostForm["email"]
+		_, hasPrivacy := r.PostForm["privacy"]
+		if hasEmail || hasPrivacy {
+			if _, _, e := getUserAndSessionFromRequest(r); e != nil {
+				resp.Error = &types.Error{Type: AUTHORIZATION, Message: "Error: email and privacy can only be changed in a signed in session"}
+				sendResponse(w, resp)
+				return
+			}
+		}
+
+		//Only fields listed here can be changed
+		update := types.ProfileUpdate{}
+		for key, values := range r.PostForm {
+
+			val := values[0]
+			var e *types.Error
+
+			switch key {
+			case "first_name":
+				e = validateFirstName(val)
+				update.FirstName = &val
+			case "last_name":
+				e = validateLastName(val)
+				update.LastName = &val
+			case "nick_name":
+				e = validateNickName(val)
+				update.NickName = &val
+			case "date_of_birth":
+				milli, err := parseDateOfBirth(val)
+				e = err
+				update.DateOfBirth = &milli
+			case "email":
+				e = validateEmail(val)
+				update.Email = &val
+			case "about_me":
+				e = validateAboutMe(val)
+				update.AboutMe = &val
+			case "privacy":
+				if val != "public" && val != "private" {
+					e = &types.Error{Type: INVALID_PRIVACY, Message: "Error: privacy should be public or private"}
+				}
+				update.Privacy = &val
+			case "email_visibility":
+				e = validateVisibility(key, val)
+				update.EmailVisibility = &val
+			case "date_of_birth_visibility":
+				e = validateVisibility(key, val)
+				update.DateOfBirthVisibility = &val
+			case "about_me_visibility":
+				e = validateVisibility(key, val)
+				update.AboutMeVisibility = &val
+			case "password":
+				e = &types.Error{Type: INVALID_PASSWORD, Message: "Error: password cannot be changed here. Use /password"}
+			default:
+				e = &types.Error{Type: INVALID_FIELD, Message: fmt.Sprintf("Error: field %v cannot be updated", key)}
+			}
+
+			if e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+		}
+
+		num, err := db.UpdateUserProfile(user.Id, update)
+		if err != nil {
+			if strings.Contains(fmt.Sprintf("%v", err), "UNIQUE constraint") {
+				resp.Error = &types.Error{Type: INVALID_EMAIL, Message: "Error: email already in use"}
+			} else {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update user in database. %v", err)}
+			}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Public account approves every pending follow request
+		if update.Privacy != nil && *update.Privacy == "public" {
+			resolveFollowRequest(0, user.Id, FOLLOW_REQUEST_APPROVED)
+		}
+
+		//Email changed. Verify new address
+		if update.Email != nil && strings.TrimSpace(strings.ToLower(*update.Email)) != user.Email {
+			user.Email = strings.TrimSpace(strings.ToLower(*update.Email))
+			err = sendVerificationEmail(user)
+			if err != nil {
+				fmt.Println("Error: could not send verification email. ", err)
+			}
+		}
+
+		resp.Payload = types.Updated{Updated: int(*num)}
+
+	} else if r.Method == "DELETE" {
+		//Schedules deletion of own account. Signing in during grace period cancels it
+		user, _, e := getUserAndSessionFromRequest(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		if user.DeletionScheduledAt > 0 {
+			resp.Error = &types.Error{Type: ACCOUNT_DELETION_SCHEDULED, Message: "Error: account deletion is already scheduled"}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Accounts created by external sign in have no password
+		if user.Password != "" && !util.CompairPasswords(user.Password, r.FormValue("password")) {
+			resp.Error = &types.Error{Type: INVALID_PASSWORD, Message: "Error: wrong password"}
+			sendResponse(w, resp)
+			return
+		}
+
+		deletionScheduledAt := util.GetCurrentMilli() + accountDeletionGracePeriod.Milliseconds()
+		_, err := db.ScheduleUserDeletion(user.Id, deletionScheduledAt)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update user in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Sign out everywhere
+		err = db.DeleteApiTokensByUserId(user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete api tokens from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		_, err = revokeUserSessions(user.Id, "")
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not revoke sessions. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		clearSessionCookie(w)
+
+		body := fmt.Sprintf("Your account is scheduled for deletion on %v.\n\n"+
+			"Sign in before that date to keep your account.", time.UnixMilli(deletionScheduledAt).UTC().Format("2006-01-02 15:04 MST"))
+		sendMail(user.Email, "Your account will be deleted", body)
+
+		resp.Payload = types.AccountDeletion{DeletionScheduledAt: deletionScheduledAt}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+// PUT /user/avatar, PUT /user/cover upload or replace the image (multipart field "image").
+// DELETE removes it. The previous file is deleted from IMAGES_DIRECTORY
+func userImageHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_PROFILE)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	column := "avatar"
+	if r.URL.Path == "/user/cover" {
+		column = "cover"
+	}
+
+	fileName := ""
+
+	if r.Method == "PUT" || r.Method == "POST" {
+		r.Body = http.MaxBytesReader(w, r.Body, int64(imageMaxBytes)+1024*1024)
+		err := r.ParseMultipartForm(10 << 20)
+		if err != nil {
+			if strings.Contains(err.Error(), "request body too large") {
+				resp.Error = &types.Error{Type: IMAGE_TOO_LARGE, Message: fmt.Sprintf("Error: image should be less than %v bytes", imageMaxBytes)}
+			} else {
+				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while parse multipart form %v", err)}
+			}
+			sendResponse(w, resp)
+			return
+		}
+
+		fileName, e = saveUploadedImage(r, "image")
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+		if fileName == "" {
+			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: image is missing"}
+			sendResponse(w, resp)
+			return
+		}
+
+	} else if r.Method != "DELETE" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	previous, err := db.UpdateUserImage(user.Id, column, fileName)
+	if err != nil {
+		removeImage(fileName)
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update user in database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if previous != fileName {
+		removeImage(previous)
+	}
+
+	if column == "avatar" {
+		user.Avatar = fileName
+	} else {
+		user.Cover = fileName
+	}
+	resp.Payload = user
+
+	sendResponse(w, resp)
+}
+
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_PROFILE)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	if r.Method == "GET" && r.URL.Path == "/users/suggestions" {
+
+		limit := SUGGESTIONS_DEFAULT_LIMIT
+		if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+			l, err := strconv.Atoi(value)
+			if err != nil || l < 1 || l > PAGE_SIZE_MAX {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: limit should be between 1 and %v", PAGE_SIZE_MAX)}
+				sendResponse(w, resp)
+				return
+			}
+			limit = l
+		}
+
+		suggestions, err := suggestFollows(user.Id, limit)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get suggestions from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = suggestions
+
+	} else if r.Method == "GET" {
+
+		//GET /users?q=&sort=name|newest&cursor=&limit=
+		cursor, limit, e := getPageParams(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		sort := strings.TrimSpace(r.URL.Query().Get("sort"))
+		if sort == "" {
+			sort = USER_SORT_NAME
+		}
+		if sort != USER_SORT_NAME && sort != USER_SORT_NEWEST {
+			resp.Error = &types.Error{Type: INVALID_SORT, Message: "Error: sort should be name or newest"}
+			sendResponse(w, resp)
+			return
+		}
+
+		//One extra row tells if there is a next page
+		users, err := db.SearchUsers(user.Id, types.UserQuery{
+			Text:  r.URL.Query().Get("q"),
+			Sort:  sort,
+			After: cursor,
+			Limit: limit + 1,
+		})
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get users from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		page := types.UserPage{Users: users}
+		if len(users) > limit {
+			page.Users = users[:limit]
+			last := page.Users[limit-1]
+			page.NextCursor = encodeCursor(types.Cursor{Value: last.SortName, Id: last.Id})
+		}
+
+		resp.Payload = page
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+	sendResponse(w, resp)
+}
+
+// Paginated home feed. GET /feed?cursor=&limit=&since=&until= where since and until are
+// milliseconds. Posts carry a comment count and the newest comments instead of all of them,
+// posts and comments carry reaction counts
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	cursor, limit, e := getPageParams(r)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+	query := types.FeedQuery{After: cursor, Limit: limit + 1}
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		value := strings.TrimSpace(r.URL.Query().Get(param.name))
+		if value == "" {
+			continue
+		}
+		milli, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || milli < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: %v should be a time in milliseconds", param.name)}
+			sendResponse(w, resp)
+			return
+		}
+		*param.value = milli
+	}
+
+	page, e := getFeedPage(user.Id, query, limit)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	resp.Payload = page
+	sendResponse(w, resp)
+}
+
+// Runs the feed query with limit+1 rows and adds comment previews and reactions to the posts
+func getFeedPage(userId int, query types.FeedQuery, limit int) (*types.FeedPage, *types.Error) {
+	posts, err := db.GetFeedPage(userId, query)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get posts from database. %v", err)}
+	}
+
+	page := types.FeedPage{Posts: []types.FeedPost{}}
+	if len(posts) > limit {
+		posts = posts[:limit]
+		last := posts[limit-1]
+		page.NextCursor = encodeCursor(types.Cursor{Value: strconv.FormatInt(last.Date, 10), Id: last.Id})
+	}
+
+	postIds := []int{}
+	for _, post := range posts {
+		postIds = append(postIds, post.Id)
+	}
+	counts, previews, err := db.GetCommentPreviews(postIds, userId, FEED_COMMENT_PREVIEW_SIZE)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comments from database. %v", err)}
+	}
+
+	commentIds := []int{}
+	for _, comments := range previews {
+		for _, comment := range comments {
+			commentIds = append(commentIds, comment.Id)
+		}
+	}
+	postReactions, err := db.GetReactionSummaries(REACTION_TARGET_POST, postIds, userId)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get reactions from database. %v", err)}
+	}
+	commentReactions, err := db.GetReactionSummaries(REACTION_TARGET_COMMENT, commentIds, userId)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get reactions from database. %v", err)}
+	}
+
+	for _, post := range posts {
+		post.Comments = previews[post.Id]
+		if post.Comments == nil {
+			post.Comments = []types.Comment{}
+		}
+		for index, comment := range post.Comments {
+			post.Comments[index].Reactions = commentReactions[comment.Id]
+		}
+		page.Posts = append(page.Posts, types.FeedPost{Post: post, CommentCount: counts[post.Id], Reactions: postReactions[post.Id]})
+	}
+
+	return &page, nil
+}
+
+// Tells connected users whose feed the new post entered that new posts are available
+func notifyNewPost(postId int, authorId int) {
+	swMessage := types.WSMessage{
+		Type:    NEW_POSTS_AVAILABLE,
+		Payload: types.NewPostsAvailable{PostId: postId, AuthorId: authorId},
+	}
+	b, err := json.Marshal(swMessage)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	userIds, err := db.GetFeedUserIds(postId, clients.UserIds())
+	if err != nil {
+		fmt.Println("Error: could not get users to notify of post ", postId, ". ", err)
+		return
+	}
+	//A stalled connection must not hold back the others
+	for _, userId := range userIds {
+		go notifyClient(userId, b)
+	}
+}
+
+// GET /hashtags/{tag}/posts?cursor=&limit= pages through posts with the hashtag the caller may see,
+// shaped like the feed. GET /hashtags/trending?hours=&limit= returns the most used hashtags of the
+// last hours (TRENDING_WINDOW by default) in posts and comments the caller may see
+func hashtagsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/hashtags"), "/"), "/")
+
+	if len(parts) == 1 && parts[0] == "trending" {
+
+		window := trendingWindow
+		if value := strings.TrimSpace(r.URL.Query().Get("hours")); value != "" {
+			hours, err := strconv.Atoi(value)
+			if err != nil || hours < 1 || hours > 24*30 {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: hours should be between 1 and %v", 24*30)}
+				sendResponse(w, resp)
+				return
 			}
+			window = time.Duration(hours) * time.Hour
+		}
 
+		limit := TRENDING_HASHTAGS_DEFAULT_LIMIT
+		if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+			l, err := strconv.Atoi(value)
+			if err != nil || l < 1 || l > PAGE_SIZE_MAX {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: limit should be between 1 and %v", PAGE_SIZE_MAX)}
+				sendResponse(w, resp)
+				return
+			}
+			limit = l
 		}
 
-	} else if r.Method == "PATCH" {
-		user, e := getUserFromRequest(r)
+		trends, err := db.GetTrendingHashtags(user.Id, util.GetCurrentMilli()-window.Milliseconds(), limit)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get hashtags from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = trends
+
+	} else if len(parts) == 2 && parts[1] == "posts" {
+
+		tag := normalizeHashtag(parts[0])
+		if tag == "" {
+			resp.Error = &types.Error{Type: INVALID_HASHTAG, Message: fmt.Sprintf("Error: invalid hashtag: %v", parts[0])}
+			sendResponse(w, resp)
+			return
+		}
 
+		cursor, limit, e := getPageParams(r)
 		if e != nil {
 			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
 
-		m := make(map[string]string)
-		err := r.ParseForm()
-		if err != nil {
-			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse form: %v", err)}
+		page, e := getFeedPage(user.Id, types.FeedQuery{Hashtag: tag, After: cursor, Limit: limit + 1}, limit)
+		if e != nil {
+			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
+		resp.Payload = page
 
-		for key, values := range r.PostForm {
+	} else {
+		resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could not parse: %v", r.URL.Path)}
+	}
 
-			val := values[0]
+	sendResponse(w, resp)
+}
 
-			//Validation
-			if key == "nick_name" {
-				if len(val) != 0 && (len(val) < 2 || len(val) > 50) {
-					resp.Error = &types.Error{Type: INVALID_NICK_NAME_FORMAT, Message: "Error: Nickname should be between 2 and 50 characters long"}
-					sendResponse(w, resp)
-					return
-				}
-			}
+// Reactions on posts and comments. The target is given by post_id or comment_id.
+// GET returns the reaction summary, POST (type) toggles the caller's reaction, DELETE removes it
+func reactionsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
 
-			if key == "first_name" {
-				if len(val) < 1 || len(val) > 50 {
-					resp.Error = &types.Error{Type: INVALID_FIRST_NAME_FORMAT, Message: "Error: First Name should be between 1 and 50 characters long"}
-					sendResponse(w, resp)
-					return
-				}
-			}
-			if key == "last_name" {
-				if len(val) < 1 || len(val) > 50 {
-					resp.Error = &types.Error{Type: INVALID_LAST_NAME_FORMAT, Message: "Error: Last Name should be between 1 and 50 characters long"}
-					sendResponse(w, resp)
-					return
-				}
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	targetType, targetId, authorId, e := getReactionTarget(r, user.Id)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	if r.Method == "POST" {
+
+		if e := checkNotBlocked(user.Id, authorId); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		reactionType := strings.ToLower(strings.TrimSpace(r.FormValue("type")))
+		valid := false
+		for _, t := range reactionTypes {
+			if t == reactionType {
+				valid = true
+				break
 			}
+		}
+		if !valid {
+			resp.Error = &types.Error{Type: INVALID_REACTION, Message: fmt.Sprintf("Error: type should be one of: %v", strings.Join(reactionTypes, ", "))}
+			sendResponse(w, resp)
+			return
+		}
+
+		previous, current, err := db.ToggleReaction(user.Id, targetType, targetId, reactionType, util.GetCurrentMilli())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save reaction to database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Notify the author of new reactions only, not of changed or removed ones
+		if previous == "" && current != "" && authorId != user.Id {
+			notifyReaction(user, authorId, targetType, current)
+		}
+
+	} else if r.Method == "DELETE" {
+
+		_, err := db.DeleteReaction(user.Id, targetType, targetId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete reaction from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+
+	} else if r.Method != "GET" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	summaries, err := db.GetReactionSummaries(targetType, []int{targetId}, user.Id)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get reactions from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	resp.Payload = summaries[targetId]
+
+	sendResponse(w, resp)
+}
+
+// Reads post_id or comment_id. Returns the target type, id and author.
+// Targets the user cannot see are reported as not found
+func getReactionTarget(r *http.Request, userId int) (string, int, int, *types.Error) {
+	targetType := REACTION_TARGET_POST
+	param := "post_id"
+	if r.FormValue("comment_id") != "" {
+		targetType = REACTION_TARGET_COMMENT
+		param = "comment_id"
+	}
+
+	value := strings.TrimSpace(r.FormValue(param))
+	targetId, err := strconv.Atoi(value)
+	if err != nil || targetId < 1 {
+		return "", 0, 0, &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: post_id or comment_id is required. Could not parse: %v", value)}
+	}
+
+	postId := targetId
+	authorId := 0
+	if targetType == REACTION_TARGET_COMMENT {
+		comment, err := db.GetCommentById(targetId)
+		if err != nil {
+			return "", 0, 0, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comment from database. %v", err)}
+		}
+		if comment == nil {
+			return "", 0, 0, &types.Error{Type: COMMENT_NOT_FOUND, Message: "Error: comment not found"}
+		}
+		postId = comment.PostId
+		authorId = comment.User.(int)
+	}
+
+	visible, err := db.CanViewPost(userId, postId)
+	if err != nil {
+		return "", 0, 0, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+	}
+	if !visible {
+		return "", 0, 0, &types.Error{Type: POST_NOT_FOUND, Message: "Error: post not found"}
+	}
+
+	if targetType == REACTION_TARGET_POST {
+		authorId, err = db.GetPostAuthorId(postId)
+		if err != nil {
+			return "", 0, 0, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+		}
+	}
+	return targetType, targetId, authorId, nil
+}
+
+// Saves a reaction notification for the author of the post or comment and sends it over ws
+func notifyReaction(user *types.User, authorId int, targetType string, reactionType string) {
+	nick := user.FirstName + " " + user.LastName
+	if user.NickName != "" {
+		nick = user.NickName
+	}
+
+	n := types.Notification{
+		Type:      NOTIFICATION_REACTION,
+		Content:   fmt.Sprintf("%v reacted with %v to your %v", nick, reactionType, targetType),
+		Sender:    types.UserBasicInfo{Id: user.Id, DisplayName: nick, Avatar: user.Avatar},
+		Recipient: authorId,
+	}
+	err := db.SaveNotification(n)
+	if err != nil {
+		fmt.Println("Error: could not save a notification to database. ", err)
+		return
+	}
+
+	swMessage := types.WSMessage{
+		Type:    NEW_NOTIFICATION,
+		Payload: n,
+	}
+	b, err := json.Marshal(swMessage)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	pushNotification(authorId, user.Id, b)
+}
+
+// Single post. PATCH /posts/{id} (content, privacy) and DELETE /posts/{id} for the author
+// or, for group posts, the group creator. GET /posts/{id}/edits returns the edit history to them
+func postHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/posts"), "/"), "/")
+	postId, err := strconv.Atoi(parts[0])
+	if err != nil || postId < 1 {
+		resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", parts[0])}
+		sendResponse(w, resp)
+		return
+	}
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "edits") {
+		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: use /posts/{id} or /posts/{id}/edits"}
+		sendResponse(w, resp)
+		return
+	}
+
+	post, err := db.GetPostById(postId)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if post == nil {
+		resp.Error = &types.Error{Type: POST_NOT_FOUND, Message: "Error: post not found"}
+		sendResponse(w, resp)
+		return
+	}
+
+	//Author or creator of the post's group
+	canManage := post.User.Id == user.Id
+	if !canManage && post.Group != nil {
+		group, err := db.GetGroupById(post.Group.(int))
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get group from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		creator, ok := group.Creator.(types.UserBasicInfo)
+		canManage = ok && creator.Id == user.Id
+	}
+	if !canManage {
+		resp.Error = &types.Error{Type: AUTHORIZATION, Message: "Error: only the author or the group creator can manage this post"}
+		sendResponse(w, resp)
+		return
+	}
+
+	if r.Method == "GET" && len(parts) == 2 {
+
+		edits, err := db.GetPostEdits(postId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post edits from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = edits
+
+	} else if r.Method == "PATCH" && len(parts) == 1 {
+
+		if e := requireVerifiedEmail(user); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		err := r.ParseMultipartForm(10 << 20)
+		if err != nil && err != http.ErrNotMultipart {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could not parse form. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
 
-			if key == "email" {
-				reg := `^[^@\s]+@[^@\s]+\.[^@\s]+$`
-				match, err := regexp.MatchString(reg, val)
-				if err != nil || !match {
-					resp.Error = &types.Error{Type: INVALID_EMAIL, Message: "Error: invalid email"}
-					sendResponse(w, resp)
-					return
-				}
-			}
+		_, hasContent := r.Form["content"]
+		_, hasPrivacy := r.Form["privacy"]
+		if !hasContent && !hasPrivacy {
+			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: content or privacy is required"}
+			sendResponse(w, resp)
+			return
+		}
 
-			if key == "about_me" {
-				if len(val) > 5000 {
-					resp.Error = &types.Error{Type: INVALID_ABOUT_ME, Message: "Error: about me should be less than 5000 charachters"}
-					sendResponse(w, resp)
-					return
-				}
+		update := *post
+		if hasContent {
+			update.Content = strings.TrimSpace(r.FormValue("content"))
+		}
+		if hasPrivacy {
+			if post.Group != nil {
+				resp.Error = &types.Error{Type: INVALID_PRIVACY, Message: "Error: group posts are always public"}
+				sendResponse(w, resp)
+				return
+			}
+			update.Privacy = strings.TrimSpace(r.FormValue("privacy"))
+			update.Audience = nil
+			update.AudienceLists = nil
+			if e := applyPostPrivacy(r, &update); e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
 			}
-
-			m[key] = val
 		}
 
-		num, err := db.UpdateUserDetails(user.Id, m)
+		date := util.GetCurrentMilli()
+		num, err := db.UpdatePost(update, user.Id, date)
 		if err != nil {
-			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update user in database. %v", err)}
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update post in database. %v", err)}
 			sendResponse(w, resp)
 			return
 		}
-
 		resp.Payload = types.Updated{Updated: int(*num)}
 
-	} else {
-		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
-	}
-
-	sendResponse(w, resp)
-}
-
-func usersHandler(w http.ResponseWriter, r *http.Request) {
-	resp := types.Response{Payload: nil, Error: nil}
+		//A new privacy or audience can bring the post into other feeds
+		if hasPrivacy && *num > 0 {
+			go notifyNewPost(postId, post.User.Id)
+		}
 
-	user, e := getUserFromRequest(r)
-	if e != nil {
-		resp.Error = e
-		sendResponse(w, resp)
-		return
-	}
+		//Mentions and hashtags belong to the author, also when the group creator edits the post
+		author := user
+		if post.User.Id != user.Id {
+			author, err = db.GetUserById(post.User.Id)
+			if err != nil || author == nil {
+				fmt.Println("Error: could not get post author from database. ", err)
+				sendResponse(w, resp)
+				return
+			}
+		}
+		indexContent(CONTENT_SOURCE_POST, postId, author, update.Content, date, postViewers(postId))
 
-	if r.Method == "GET" {
+	} else if r.Method == "DELETE" && len(parts) == 1 {
 
-		users, err := db.GetUsers(user.Id)
+		images, err := db.DeletePost(postId)
 		if err != nil {
-			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get users from database. %v", err)}
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete post from database. %v", err)}
 			sendResponse(w, resp)
 			return
 		}
-
-		resp.Payload = users
+		for _, image := range images {
+			removeImage(image)
+		}
+		resp.Payload = types.RowsAffected{RowsAffected: 1}
 
 	} else {
 		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
 	}
+
 	sendResponse(w, resp)
 }
 
@@ -470,6 +2388,12 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := types.Response{Payload: nil, Error: nil}
 
+	//Single post
+	if strings.Trim(strings.TrimPrefix(r.URL.Path, "/posts"), "/") != "" {
+		postHandler(w, r)
+		return
+	}
+
 	//Check valid methods
 	if r.Method != "GET" && r.Method != "POST" {
 		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
@@ -477,7 +2401,7 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
 
 	if e != nil {
 		resp.Error = e
@@ -487,6 +2411,12 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 
 	//New Post
 	if r.Method == "POST" {
+		if e := requireVerifiedEmail(user); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
 		err := r.ParseMultipartForm(10 << 20)
 		if err != nil {
 			resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while parse multipart form %v", err)}
@@ -596,12 +2526,22 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 			post.Privacy = "public"
 		}
 
+		if post.Group == nil {
+			if e := applyPostPrivacy(r, &post); e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+		}
+
 		id, err := db.SavePost(post)
 
 		if err != nil {
 			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save post to database: %v", err)}
 		} else {
 			resp.Payload = types.PostId{PostId: int(*id)}
+			indexContent(CONTENT_SOURCE_POST, int(*id), user, post.Content, util.GetCurrentMilli(), postViewers(int(*id)))
+			go notifyNewPost(int(*id), user.Id)
 		}
 	}
 
@@ -657,7 +2597,7 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			posts, err := db.GetPostsByGroupId(id)
+			posts, err := db.GetPostsByGroupId(id, user.Id)
 			if err != nil {
 				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get posts from database. %v", err)}
 				sendResponse(w, resp)
@@ -666,7 +2606,7 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 
 			//Get comments
 			for index, post := range *posts {
-				comments, err := db.GetComments(post.Id)
+				comments, err := db.GetComments(post.Id, user.Id)
 				if err != nil {
 					resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comments from database. %v", err)}
 					sendResponse(w, resp)
@@ -698,7 +2638,7 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 
 				//Get Comments
 				for index, post := range *posts {
-					comments, err := db.GetComments(post.Id)
+					comments, err := db.GetComments(post.Id, user.Id)
 					if err != nil {
 						resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comments from database. %v", err)}
 						sendResponse(w, resp)
@@ -711,25 +2651,9 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 			} else {
 				//Someone else profile
 
-				person, err := db.GetUserById(id)
-				if err != nil {
-					resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get person from database. %v", err)}
-					sendResponse(w, resp)
-					return
-				}
-
-				//Check Profile Privacy
-				followings, err := db.GetFollowing(user.Id)
-				isFollowing := false
-				for _, f := range *followings {
-					if f.Following.Id == id {
-						isFollowing = true
-						break
-					}
-				}
-
-				if person.Privacy == "private" && !isFollowing {
-					resp.Error = &types.Error{Type: AUTHORIZATION, Message: fmt.Sprintf("Error: no access - private account. %v", err)}
+				//Check Profile Privacy and blocks
+				if e := isAccessRestricted(user.Id, id); e != nil {
+					resp.Error = e
 					sendResponse(w, resp)
 					return
 				}
@@ -743,7 +2667,7 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 
 				//Get Comments
 				for index, post := range *posts {
-					comments, err := db.GetComments(post.Id)
+					comments, err := db.GetComments(post.Id, user.Id)
 					if err != nil {
 						resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comments from database. %v", err)}
 						sendResponse(w, resp)
@@ -763,28 +2687,9 @@ func postsHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			//Add groups
-			for index, post := range *posts {
-				if post.Group != nil {
-					group, err := db.GetGroupById(post.Group.(*types.Group).Id)
-					if err != nil {
-						resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get group from database. %v", err)}
-						sendResponse(w, resp)
-						return
-					}
-					groupBasicInfo := types.GroupBasicInfo{
-						Id:          group.Id,
-						Title:       group.Title,
-						Description: group.Description,
-						Image:       group.Image}
-
-					(*posts)[index].Group = groupBasicInfo
-				}
-			}
-
 			//Get Comments
 			for index, post := range *posts {
-				comments, err := db.GetComments(post.Id)
+				comments, err := db.GetComments(post.Id, user.Id)
 				if err != nil {
 
 					resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comments from database. %v", err)}
@@ -810,79 +2715,202 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		sendResponse(w, resp)
 	} else {
 
-		user, err := getUserFromRequest(r)
+		//Browsers cannot set headers on websocket upgrade, so session_id query parameter is allowed here
+		user, session, err := getUserAndSessionBySessionId(getSessionIdFromRequest(r, wsQuerySessionIdEnabled))
 		if err != nil {
 			resp.Error = err
 			sendResponse(w, resp)
 			return
 		}
 
-		addClient(*user, w, r)
+		addClient(*user, session.SessionId, w, r)
 	}
 }
 
-func followingHandler(w http.ResponseWriter, r *http.Request) {
+// GET /followers and /following of the user or of person_id, cursor paginated.
+// /followers/count and /following/count return counts of approved follows.
+// Pending requests are listed only to the owner
+func getFollowList(r *http.Request, user *types.User, followers bool) (interface{}, *types.Error) {
+
+	personId := user.Id
+	if personIdStr := strings.TrimSpace(r.URL.Query().Get("person_id")); personIdStr != "" {
+		id, err := strconv.Atoi(personIdStr)
+		if err != nil {
+			return nil, &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could not parse: %v", personIdStr)}
+		}
+		personId = id
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/count") {
+		//Counts of private accounts are public, blocked users get nothing
+		if e := isAccessRestricted(user.Id, personId); e != nil && e.Type != AUTHORIZATION {
+			return nil, e
+		}
+		counts, err := db.GetFollowCounts(personId)
+		if err != nil {
+			return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get followers from database. %v", err)}
+		}
+		return counts, nil
+	}
+
+	//Check Privacy
+	if e := isAccessRestricted(user.Id, personId); e != nil {
+		return nil, e
+	}
+
+	cursor, limit, e := getPageParams(r)
+	if e != nil {
+		return nil, e
+	}
+
+	var edges []types.FollowEdge
+	var err error
+	if followers {
+		edges, err = db.GetFollowers(personId, personId != user.Id, cursor, limit+1)
+	} else {
+		edges, err = db.GetFollowing(personId, personId != user.Id, cursor, limit+1)
+	}
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get followers from database. %v", err)}
+	}
+
+	page := types.FollowPage{Users: edges}
+	if len(edges) > limit {
+		page.Users = edges[:limit]
+		last := page.Users[limit-1]
+		page.NextCursor = encodeCursor(types.Cursor{Value: strconv.FormatInt(last.Date, 10), Id: last.Id})
+	}
+	return page, nil
+}
+
+// Incoming and outgoing follow requests.
+// GET /follow-requests/incoming and /follow-requests/outgoing list pending requests (cursor paginated),
+// DELETE /follow-requests/incoming/{user_id} rejects a request, DELETE /follow-requests/outgoing/{user_id} cancels own request
+func followRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	if r.Method != "GET" && r.Method != "POST" && r.Method != "OPTIONS" && r.Method != "DELETE" {
-		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	user, e := getUserFromRequestWithScope(r, SCOPE_FOLLOWS)
+	if e != nil {
+		resp.Error = e
 		sendResponse(w, resp)
 		return
 	}
 
-	user, err := getUserFromRequest(r)
-	if err != nil {
-		resp.Error = err
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/follow-requests"), "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "incoming" && parts[0] != "outgoing" {
+		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: use /follow-requests/incoming or /follow-requests/outgoing"}
 		sendResponse(w, resp)
 		return
 	}
+	incoming := parts[0] == "incoming"
 
-	if r.Method == "GET" {
+	if r.Method == "GET" && len(parts) == 1 {
 
-		person_id_str := ""
-		params, ok := r.URL.Query()["person_id"]
-		if ok && len(params[0]) > 0 {
-			person_id_str = params[0]
+		cursor, limit, e := getPageParams(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
 		}
 
-		if person_id_str != "" {
+		edges, err := db.GetFollowRequests(user.Id, incoming, cursor, limit+1)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get follow requests from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
 
-			person_id, err := strconv.Atoi(person_id_str)
-			if err != nil {
-				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could not parse: %v", person_id_str)}
-				sendResponse(w, resp)
-				return
-			}
+		page := types.FollowPage{Users: edges}
+		if len(edges) > limit {
+			page.Users = edges[:limit]
+			last := page.Users[limit-1]
+			page.NextCursor = encodeCursor(types.Cursor{Value: strconv.FormatInt(last.Date, 10), Id: last.Id})
+		}
+		resp.Payload = page
 
-			//Check Privacy
-			e := isAccessRestricted(user.Id, person_id)
-			if e != nil {
-				fmt.Println("e: ", e)
-				resp.Error = e
-				sendResponse(w, resp)
-				return
-			}
+	} else if r.Method == "DELETE" && len(parts) == 2 {
 
-			following, err := db.GetFollowing(person_id)
+		personId, err := strconv.Atoi(parts[1])
+		if err != nil || personId < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", parts[1])}
+			sendResponse(w, resp)
+			return
+		}
 
-			if err != nil {
-				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not get followers from database"}
-				sendResponse(w, resp)
-				return
-			}
+		followerId, followeeId, status := user.Id, personId, FOLLOW_REQUEST_CANCELLED
+		if incoming {
+			followerId, followeeId, status = personId, user.Id, FOLLOW_REQUEST_REJECTED
+		}
+
+		num, err := db.DeleteFollowRequest(followerId, followeeId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete follow request from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if *num == 0 {
+			resp.Error = &types.Error{Type: FOLLOW_REQUEST_NOT_FOUND, Message: "Error: no pending follow request found"}
+			sendResponse(w, resp)
+			return
+		}
 
-			resp.Payload = following
+		resolveFollowRequest(followerId, followeeId, status)
+		resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
 
-		} else {
-			following, err := db.GetFollowing(user.Id)
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
 
-			if err != nil {
-				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not get followers from database"}
-				sendResponse(w, resp)
-				return
-			}
-			resp.Payload = following
-		}
+	sendResponse(w, resp)
+}
+
+// Marks the follow request notification as no longer actionable and tells both users over ws.
+// followerId 0 resolves requests from every follower
+func resolveFollowRequest(followerId int, followeeId int, status string) {
+	num, err := db.UpdateNotificationActionStatus(NOTIFICATION_FOLLOW_ACTION_REQUEST, followerId, followeeId, status)
+	if err != nil {
+		fmt.Println("Error: could not update follow request notification. ", err)
+		return
+	}
+	if *num == 0 {
+		return
+	}
+
+	swMessage := types.WSMessage{
+		Type:    FOLLOW_REQUEST_UPDATED,
+		Payload: types.FollowRequestUpdate{FollowerId: followerId, FolloweeId: followeeId, Status: status},
+	}
+	b, err := json.Marshal(swMessage)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	notifyClient(followeeId, b)
+	if followerId != 0 {
+		notifyClient(followerId, b)
+	}
+}
+
+func followingHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" && r.Method != "POST" && r.Method != "OPTIONS" && r.Method != "DELETE" {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		sendResponse(w, resp)
+		return
+	}
+
+	user, err := getUserFromRequestWithScope(r, SCOPE_FOLLOWS)
+	if err != nil {
+		resp.Error = err
+		sendResponse(w, resp)
+		return
+	}
+
+	if r.Method == "GET" {
+
+		resp.Payload, resp.Error = getFollowList(r, user, false)
 
 	} else if r.Method == "POST" {
 
@@ -898,6 +2926,13 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 		followee, err := db.GetUserById(following)
 		if err != nil {
 			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not get following from database"}
+			sendResponse(w, resp)
+			return
+		}
+		if followee == nil {
+			resp.Error = &types.Error{Type: NO_USER_FOUND, Message: "Error: user not found"}
+			sendResponse(w, resp)
+			return
 		}
 
 		if user.Id == followee.Id {
@@ -906,6 +2941,12 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if e := checkNotBlocked(user.Id, followee.Id); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
 		err = db.UpdateFollowers(user.Id, following, followee.Privacy)
 
 		if err != nil {
@@ -947,19 +2988,22 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 		//1. Notification to recipient
 		notificationType := ""
 		content := ""
+		actionStatus := ""
 		if followee.Privacy == "private" {
 			content = nick + " wants to be your follower. Approval needed."
 			notificationType = NOTIFICATION_FOLLOW_ACTION_REQUEST
+			actionStatus = FOLLOW_REQUEST_PENDING
 		} else if followee.Privacy == "public" {
 			content = "You have a new follower: " + nick
 			notificationType = NOTIFICATION_FOLLOW_INFO
 		}
 
 		n := types.Notification{
-			Type:      notificationType,
-			Content:   content,
-			Sender:    userBasicInfo,
-			Recipient: followeeBasicInfo}
+			Type:         notificationType,
+			Content:      content,
+			Sender:       userBasicInfo,
+			Recipient:    followeeBasicInfo,
+			ActionStatus: actionStatus}
 
 		err = db.SaveNotification(n)
 		if err != nil {
@@ -975,7 +3019,7 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		b, err := json.Marshal(swMessage)
 		if err == nil {
-			notifyClient(followee.Id, b)
+			pushNotification(followee.Id, user.Id, b)
 		} else {
 			fmt.Println(err)
 		}
@@ -1008,7 +3052,7 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		b, err = json.Marshal(swMessage)
 		if err == nil {
-			notifyClient(user.Id, b)
+			pushNotification(user.Id, followee.Id, b)
 		} else {
 			fmt.Println(err)
 		}
@@ -1038,6 +3082,8 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 
 		if err != nil {
 			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Error: could not delete follower from database"}
+		} else {
+			resolveFollowRequest(user.Id, followingId, FOLLOW_REQUEST_CANCELLED)
 		}
 
 		following, err := db.GetUserById(followingId)
@@ -1091,7 +3137,7 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		b, err := json.Marshal(swMessage)
 		if err == nil {
-			notifyClient(following.Id, b)
+			pushNotification(following.Id, user.Id, b)
 		} else {
 			fmt.Println(err)
 		}
@@ -1110,81 +3156,37 @@ func followingHandler(w http.ResponseWriter, r *http.Request) {
 			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not save a notification to database"}
 			sendResponse(w, resp)
 			return
-		}
-
-		//Send Message to sender
-		swMessage = types.WSMessage{
-			Type:    NEW_NOTIFICATION,
-			Payload: n,
-		}
-		b, err = json.Marshal(swMessage)
-		if err == nil {
-			notifyClient(user.Id, b)
-		} else {
-			fmt.Println(err)
-		}
-	}
-	sendResponse(w, resp)
-}
-
-func followersHandler(w http.ResponseWriter, r *http.Request) {
-
-	resp := types.Response{Payload: nil, Error: nil}
-
-	user, err := getUserFromRequest(r)
-	if err != nil {
-		resp.Error = err
-		sendResponse(w, resp)
-		return
-	}
-
-	if r.Method == "GET" {
-
-		person_id_str := ""
-		params, ok := r.URL.Query()["person_id"]
-		if ok && len(params[0]) > 0 {
-			person_id_str = params[0]
-		}
-
-		if person_id_str != "" {
-			person_id, err := strconv.Atoi(person_id_str)
-			if err != nil {
-				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", person_id_str)}
-				sendResponse(w, resp)
-				return
-			}
-
-			//Check Privacy
-			e := isAccessRestricted(user.Id, person_id)
-			if e != nil {
-				fmt.Println("e: ", e)
-				resp.Error = e
-				sendResponse(w, resp)
-				return
-			}
-
-			followers, err := db.GetFollowers(person_id)
-
-			if err != nil {
-				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not get followers from database"}
-				sendResponse(w, resp)
-				return
-			}
-
-			resp.Payload = followers
+		}
 
+		//Send Message to sender
+		swMessage = types.WSMessage{
+			Type:    NEW_NOTIFICATION,
+			Payload: n,
+		}
+		b, err = json.Marshal(swMessage)
+		if err == nil {
+			pushNotification(user.Id, following.Id, b)
 		} else {
+			fmt.Println(err)
+		}
+	}
+	sendResponse(w, resp)
+}
 
-			followers, err := db.GetFollowers(user.Id)
+func followersHandler(w http.ResponseWriter, r *http.Request) {
 
-			if err != nil {
-				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not get followers from database"}
-				sendResponse(w, resp)
-				return
-			}
+	resp := types.Response{Payload: nil, Error: nil}
 
-			resp.Payload = followers
-		}
+	user, err := getUserFromRequestWithScope(r, SCOPE_FOLLOWS)
+	if err != nil {
+		resp.Error = err
+		sendResponse(w, resp)
+		return
+	}
+
+	if r.Method == "GET" {
+
+		resp.Payload, resp.Error = getFollowList(r, user, true)
 
 		sendResponse(w, resp)
 
@@ -1238,6 +3240,12 @@ func followersHandler(w http.ResponseWriter, r *http.Request) {
 
 		}
 
+		if approved == "true" {
+			resolveFollowRequest(followerId, followeeId, FOLLOW_REQUEST_APPROVED)
+		} else if approved == "false" {
+			resolveFollowRequest(followerId, followeeId, FOLLOW_REQUEST_REJECTED)
+		}
+
 		sendResponse(w, resp)
 
 		userNick := user.FirstName + " " + user.LastName
@@ -1286,7 +3294,7 @@ func followersHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			b, err := json.Marshal(swMessage)
 			if err == nil {
-				notifyClient(follower.Id, b)
+				pushNotification(follower.Id, user.Id, b)
 			} else {
 				fmt.Println(err)
 			}
@@ -1312,7 +3320,7 @@ func followersHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			b, err = json.Marshal(swMessage)
 			if err == nil {
-				notifyClient(user.Id, b)
+				pushNotification(user.Id, follower.Id, b)
 			} else {
 				fmt.Println(err)
 			}
@@ -1343,46 +3351,421 @@ func followersHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			b, err := json.Marshal(swMessage)
 			if err == nil {
-				notifyClient(follower.Id, b)
+				pushNotification(follower.Id, user.Id, b)
+			} else {
+				fmt.Println(err)
+			}
+
+			//Notification for followee
+			notificationType = NOTIFICATION_FOLLOW_INFO
+			content = "You have rejected a follow request from: " + followerNick
+			n = types.Notification{
+				Type:      notificationType,
+				Content:   content,
+				Sender:    followerBasicInfo,
+				Recipient: userBasicInfo,
+			}
+			err = db.SaveNotification(n)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not save a notification to database"}
+				sendResponse(w, resp)
+				return
+			}
+
+			//Send Message to followee
+			swMessage = types.WSMessage{
+				Type:    NEW_NOTIFICATION,
+				Payload: n,
+			}
+			b, err = json.Marshal(swMessage)
+			if err == nil {
+				pushNotification(user.Id, follower.Id, b)
 			} else {
 				fmt.Println(err)
 			}
+		}
+	}
+}
+
+// Blocks and mutes. GET /blocks lists blocked users, POST /blocks blocks user_id,
+// DELETE /blocks/{user_id} unblocks. /mutes works the same way
+func userRelationsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_FOLLOWS)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	isBlock := strings.HasPrefix(r.URL.Path, "/blocks")
+	prefix := "/mutes/"
+	if isBlock {
+		prefix = "/blocks/"
+	}
+
+	if r.Method == "GET" {
+
+		var relations *[]types.UserRelation
+		var err error
+		if isBlock {
+			relations, err = db.GetBlockedUsers(user.Id)
+		} else {
+			relations, err = db.GetMutedUsers(user.Id)
+		}
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get users from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = relations
+
+	} else if r.Method == "POST" {
+
+		personIdStr := strings.TrimSpace(r.FormValue("user_id"))
+		personId, err := strconv.Atoi(personIdStr)
+		if err != nil || personId < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", personIdStr)}
+			sendResponse(w, resp)
+			return
+		}
+		if personId == user.Id {
+			resp.Error = &types.Error{Type: AUTHORIZATION, Message: "Error: cannot block or mute yourself"}
+			sendResponse(w, resp)
+			return
+		}
+
+		person, err := db.GetUserById(personId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get user from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if person == nil {
+			resp.Error = &types.Error{Type: NO_USER_FOUND, Message: "Error: user not found"}
+			sendResponse(w, resp)
+			return
+		}
+
+		var num *int64
+		if isBlock {
+			num, err = db.BlockUser(user.Id, personId, util.GetCurrentMilli())
+		} else {
+			num, err = db.MuteUser(user.Id, personId, util.GetCurrentMilli())
+		}
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save to database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if isBlock {
+			//Blocking removes follow requests in both directions
+			resolveFollowRequest(user.Id, personId, FOLLOW_REQUEST_CANCELLED)
+			resolveFollowRequest(personId, user.Id, FOLLOW_REQUEST_CANCELLED)
+		}
+		resp.Payload = types.Inserted{Inserted: int(*num)}
+
+	} else if r.Method == "DELETE" {
+
+		personIdStr := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, prefix))
+		personId, err := strconv.Atoi(personIdStr)
+		if err != nil || personId < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", personIdStr)}
+			sendResponse(w, resp)
+			return
+		}
+
+		var num *int64
+		if isBlock {
+			num, err = db.UnblockUser(user.Id, personId)
+		} else {
+			num, err = db.UnmuteUser(user.Id, personId)
+		}
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+// Named audience lists used as post privacy.
+// GET /audience-lists, POST /audience-lists (name),
+// GET, PATCH (name) and DELETE /audience-lists/{id},
+// POST /audience-lists/{id}/members (user_id) and DELETE /audience-lists/{id}/members/{user_id}
+func audienceListsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
+	if e != nil {
+		resp.Error = e
+		sendResponse(w, resp)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/audience-lists"), "/")
+
+	//List and create
+	if path == "" {
+		if r.Method == "GET" {
+			lists, err := db.GetAudienceLists(user.Id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get audience lists from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = lists
+
+		} else if r.Method == "POST" {
+			name, e := validateAudienceListName(r.FormValue("name"))
+			if e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+
+			date := util.GetCurrentMilli()
+			id, err := db.CreateAudienceList(user.Id, name, date)
+			if err != nil {
+				resp.Error = audienceListSaveError(err)
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = types.AudienceList{Id: int(*id), Name: name, Date: date}
+
+		} else {
+			resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		}
+
+		sendResponse(w, resp)
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	listId, err := strconv.Atoi(parts[0])
+	if err != nil || listId < 1 {
+		resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", parts[0])}
+		sendResponse(w, resp)
+		return
+	}
+
+	list, err := db.GetAudienceList(listId, user.Id)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get audience list from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if list == nil {
+		resp.Error = &types.Error{Type: AUDIENCE_LIST_NOT_FOUND, Message: "Error: audience list not found"}
+		sendResponse(w, resp)
+		return
+	}
+
+	//Single list
+	if len(parts) == 1 {
+		if r.Method == "GET" {
+			resp.Payload = list
+
+		} else if r.Method == "PATCH" {
+			name, e := validateAudienceListName(r.FormValue("name"))
+			if e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+
+			num, err := db.RenameAudienceList(listId, user.Id, name)
+			if err != nil {
+				resp.Error = audienceListSaveError(err)
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = types.Updated{Updated: int(*num)}
+
+		} else if r.Method == "DELETE" {
+			num, err := db.DeleteAudienceList(listId, user.Id)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete audience list from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+
+		} else {
+			resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		}
+
+		sendResponse(w, resp)
+		return
+	}
+
+	if parts[1] != "members" || len(parts) > 3 {
+		resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: use /audience-lists/{id}/members"}
+		sendResponse(w, resp)
+		return
+	}
+
+	//Members
+	if r.Method == "POST" && len(parts) == 2 {
+
+		personIdStr := strings.TrimSpace(r.FormValue("user_id"))
+		personId, err := strconv.Atoi(personIdStr)
+		if err != nil || personId < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", personIdStr)}
+			sendResponse(w, resp)
+			return
+		}
+		if personId == user.Id {
+			resp.Error = &types.Error{Type: AUTHORIZATION, Message: "Error: cannot add yourself to an audience list"}
+			sendResponse(w, resp)
+			return
+		}
+
+		person, err := db.GetUserById(personId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get user from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if person == nil {
+			resp.Error = &types.Error{Type: NO_USER_FOUND, Message: "Error: user not found"}
+			sendResponse(w, resp)
+			return
+		}
+		if e := checkNotBlocked(user.Id, personId); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		num, err := db.AddAudienceListMember(listId, personId, util.GetCurrentMilli())
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save audience list member to database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.Inserted{Inserted: int(*num)}
+
+	} else if r.Method == "DELETE" && len(parts) == 3 {
+
+		personId, err := strconv.Atoi(parts[2])
+		if err != nil || personId < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", parts[2])}
+			sendResponse(w, resp)
+			return
+		}
+
+		num, err := db.RemoveAudienceListMember(listId, personId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete audience list member from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.RowsAffected{RowsAffected: int(*num)}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+func validateAudienceListName(value string) (string, *types.Error) {
+	name := strings.TrimSpace(value)
+	if name == "" || len([]rune(name)) > AUDIENCE_LIST_NAME_MAX_LENGTH {
+		return "", &types.Error{Type: INVALID_AUDIENCE_LIST_NAME, Message: fmt.Sprintf("Error: name should be 1 to %v characters", AUDIENCE_LIST_NAME_MAX_LENGTH)}
+	}
+	return name, nil
+}
+
+func audienceListSaveError(err error) *types.Error {
+	if strings.Contains(fmt.Sprintf("%v", err), "UNIQUE constraint") {
+		return &types.Error{Type: INVALID_AUDIENCE_LIST_NAME, Message: "Error: you already have an audience list with this name"}
+	}
+	return &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save audience list to database. %v", err)}
+}
+
+// Validates post.Privacy: public, private, audience (with audience_id values) or
+// a JSON array of user ids, which is stored as specific with the ids in post.Audience
+func applyPostPrivacy(r *http.Request, post *types.Post) *types.Error {
+	switch post.Privacy {
+	case POST_PRIVACY_PUBLIC, POST_PRIVACY_PRIVATE:
+		return nil
+	case POST_PRIVACY_AUDIENCE:
+		listIds, e := getPostAudienceLists(r, post.User.Id)
+		if e != nil {
+			return e
+		}
+		post.AudienceLists = listIds
+		return nil
+	}
+
+	userIds := []int{}
+	err := json.Unmarshal([]byte(post.Privacy), &userIds)
+	if err != nil || len(userIds) == 0 {
+		return &types.Error{Type: INVALID_PRIVACY, Message: "Error: privacy should be public, private, audience or a list of user ids"}
+	}
+
+	post.Audience = []int{}
+	seen := map[int]bool{}
+	for _, id := range userIds {
+		if id < 1 {
+			return &types.Error{Type: INVALID_PRIVACY, Message: fmt.Sprintf("Error: invalid user id in privacy: %v", id)}
+		}
+		if !seen[id] {
+			seen[id] = true
+			post.Audience = append(post.Audience, id)
+		}
+	}
+	post.Privacy = POST_PRIVACY_SPECIFIC
+	return nil
+}
 
-			//Notification for followee
-			notificationType = NOTIFICATION_FOLLOW_INFO
-			content = "You have rejected a follow request from: " + followerNick
-			n = types.Notification{
-				Type:      notificationType,
-				Content:   content,
-				Sender:    followerBasicInfo,
-				Recipient: userBasicInfo,
-			}
-			err = db.SaveNotification(n)
-			if err != nil {
-				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: "Could not save a notification to database"}
-				sendResponse(w, resp)
-				return
+// Reads audience_id values (repeated or comma separated) and checks they are lists owned by the user
+func getPostAudienceLists(r *http.Request, userId int) ([]int, *types.Error) {
+	listIds := []int{}
+	seen := map[int]bool{}
+	for _, value := range r.Form["audience_id"] {
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
 			}
-
-			//Send Message to followee
-			swMessage = types.WSMessage{
-				Type:    NEW_NOTIFICATION,
-				Payload: n,
+			id, err := strconv.Atoi(s)
+			if err != nil || id < 1 {
+				return nil, &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", s)}
 			}
-			b, err = json.Marshal(swMessage)
-			if err == nil {
-				notifyClient(user.Id, b)
-			} else {
-				fmt.Println(err)
+			if !seen[id] {
+				seen[id] = true
+				listIds = append(listIds, id)
 			}
 		}
 	}
+	if len(listIds) == 0 {
+		return nil, &types.Error{Type: MISSING_PARAM, Message: "Error: audience_id is required for audience posts"}
+	}
+
+	owned, err := db.OwnsAudienceLists(userId, listIds)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get audience lists from database. %v", err)}
+	}
+	if !owned {
+		return nil, &types.Error{Type: AUDIENCE_LIST_NOT_FOUND, Message: "Error: audience list not found"}
+	}
+	return listIds, nil
 }
 
 func notificationsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, err := getUserFromRequest(r)
+	user, err := getUserFromRequestWithScope(r, SCOPE_NOTIFICATIONS)
 	if err != nil {
 		resp.Error = err
 		sendResponse(w, resp)
@@ -1491,7 +3874,7 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_GROUPS)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
@@ -1560,6 +3943,12 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	} else if r.Method == "POST" {
+		if e := requireVerifiedEmail(user); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
 		title := strings.TrimSpace(r.FormValue("title"))
 		description := strings.TrimSpace(r.FormValue("description"))
 
@@ -1614,7 +4003,7 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	} else if r.Method == "PATCH" {
-		//URL example  /groups/1?action=invite&member=3&session_id=dbs-cvewf7cewfw-cew0vwev
+		//URL example  /groups/1?action=invite&member=3
 
 		if group_id_str == "" || group_id < 1 {
 			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing parameter: group_id"}
@@ -1688,6 +4077,12 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			if e := checkNotBlocked(user.Id, member_id); e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+
 			date := time.Now().UnixNano() / 1000000
 
 			num, err := db.SaveInvitationToGroup(user.Id, group_id, member_id, date)
@@ -1740,7 +4135,7 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			b, err := json.Marshal(swMessage)
 			if err == nil {
-				notifyClient(member_id, b)
+				pushNotification(member_id, inviter.Id, b)
 			} else {
 				fmt.Println(err)
 			}
@@ -1907,7 +4302,7 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			b, err := json.Marshal(swMessage)
 			if err == nil {
-				notifyClient(group.Creator.(types.UserBasicInfo).Id, b)
+				pushNotification(group.Creator.(types.UserBasicInfo).Id, user.Id, b)
 			} else {
 				fmt.Println(err)
 			}
@@ -1924,7 +4319,7 @@ func groupsHandler(w http.ResponseWriter, r *http.Request) {
 func groupsInvitesHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_GROUPS)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
@@ -2116,7 +4511,7 @@ func groupsInvitesHandler(w http.ResponseWriter, r *http.Request) {
 func groupJoinRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_GROUPS)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
@@ -2318,76 +4713,107 @@ func groupJoinRequestsHandler(w http.ResponseWriter, r *http.Request) {
 func commentsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_POSTS)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
 		return
 	}
 
+	//Single comment
+	if strings.Trim(strings.TrimPrefix(r.URL.Path, "/comments"), "/") != "" {
+		commentHandler(w, r, user)
+		return
+	}
+
 	if r.Method == "GET" {
 
-	} else if r.Method == "POST" {
+		postIdStr := strings.TrimSpace(r.URL.Query().Get("post_id"))
+		postId, err := strconv.Atoi(postIdStr)
+		if err != nil || postId < 1 {
+			resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", postIdStr)}
+			sendResponse(w, resp)
+			return
+		}
 
-		//handle Image
-		err := r.ParseMultipartForm(10 << 20)
+		visible, err := db.CanViewPost(user.Id, postId)
 		if err != nil {
-			resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while parse multipart form %v", err)}
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if !visible {
+			resp.Error = &types.Error{Type: POST_NOT_FOUND, Message: "Error: post not found"}
 			sendResponse(w, resp)
 			return
 		}
 
-		//Validate image
-		fileName := ""
-
-		file, _, err := r.FormFile("image")
-
-		if err == nil {
-
-			defer file.Close()
-
-			err = makeDirectoryIfNotExists(IMAGES_DIRECTORY)
-
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while creating directory %v", err)}
+		//Replies to parent_id, top level comments without it
+		parentId := 0
+		if parentIdStr := strings.TrimSpace(r.URL.Query().Get("parent_id")); parentIdStr != "" {
+			parentId, err = strconv.Atoi(parentIdStr)
+			if err != nil || parentId < 1 {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", parentIdStr)}
 				sendResponse(w, resp)
 				return
 			}
+		}
 
-			uuid := generateSessionId()
+		cursor, limit, e := getPageParams(r)
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
 
-			tempFile, err := ioutil.TempFile(IMAGES_DIRECTORY, fmt.Sprintf("%v-*", uuid))
+		comments, err := db.GetCommentsPage(postId, parentId, user.Id, cursor, limit+1)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comments from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
 
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while creating temp file %v", err)}
-				sendResponse(w, resp)
-				return
-			}
+		page := types.CommentPage{Comments: comments}
+		if len(comments) > limit {
+			page.Comments = comments[:limit]
+			last := page.Comments[limit-1]
+			page.NextCursor = encodeCursor(types.Cursor{Value: strconv.FormatInt(last.Date, 10), Id: last.Id})
+		}
 
-			defer tempFile.Close()
+		commentIds := []int{}
+		for _, comment := range page.Comments {
+			commentIds = append(commentIds, comment.Id)
+		}
+		reactions, err := db.GetReactionSummaries(REACTION_TARGET_COMMENT, commentIds, user.Id)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get reactions from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		for index, comment := range page.Comments {
+			page.Comments[index].Reactions = reactions[comment.Id]
+		}
 
-			fileBytes, err := ioutil.ReadAll(file)
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while reading file %v", err)}
-				sendResponse(w, resp)
-				return
-			}
+		resp.Payload = page
 
-			_, err = tempFile.Write(fileBytes)
-			if err != nil {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while writing file %v", err)}
-				sendResponse(w, resp)
-				return
-			}
+	} else if r.Method == "POST" {
 
-			fileName = filepath.Base(tempFile.Name())
+		if e := requireVerifiedEmail(user); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
 
-		} else {
-			if !strings.Contains(err.Error(), "no such file") {
-				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: image error %v", err)}
-				sendResponse(w, resp)
-				return
+		r.Body = http.MaxBytesReader(w, r.Body, int64(imageMaxBytes)+1024*1024)
+		err := r.ParseMultipartForm(10 << 20)
+		if err != nil {
+			if strings.Contains(err.Error(), "request body too large") {
+				resp.Error = &types.Error{Type: IMAGE_TOO_LARGE, Message: fmt.Sprintf("Error: image should be less than %v bytes", imageMaxBytes)}
+			} else {
+				resp.Error = &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while parse multipart form %v", err)}
 			}
+			sendResponse(w, resp)
+			return
 		}
 
 		postIdStr := strings.TrimSpace(r.FormValue("post_id"))
@@ -2398,9 +4824,35 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		content := strings.TrimSpace(r.FormValue("content"))
-		if content == "" || len(content) > 250 {
-			resp.Error = &types.Error{Type: INVALID_COMMENT_FORMAT, Message: "Error: comment shoud be between 1 and 250 characters long"}
+		//Only posts the user can see (privacy, group membership) can be commented
+		visible, err := db.CanViewPost(user.Id, postId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if !visible {
+			resp.Error = &types.Error{Type: POST_NOT_FOUND, Message: fmt.Sprintf("Error: post not found: %v", postId)}
+			sendResponse(w, resp)
+			return
+		}
+
+		//Blocked users cannot comment on posts of the blocker
+		authorId, err := db.GetPostAuthorId(postId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		if e := checkNotBlocked(user.Id, authorId); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		content, e := validateCommentContent(r.FormValue("content"))
+		if e != nil {
+			resp.Error = e
 			sendResponse(w, resp)
 			return
 		}
@@ -2411,17 +4863,60 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			Date:    date,
 			PostId:  postId,
 			Content: content,
-			User:    user.Id,
-			Image:   fileName}
+			User:    user.Id}
+
+		//Reply
+		if parentIdStr := strings.TrimSpace(r.FormValue("parent_id")); parentIdStr != "" {
+			parentId, err := strconv.Atoi(parentIdStr)
+			if err != nil || parentId < 1 {
+				resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", parentIdStr)}
+				sendResponse(w, resp)
+				return
+			}
+			parent, err := db.GetCommentById(parentId)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comment from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			if parent == nil || parent.PostId != postId {
+				resp.Error = &types.Error{Type: COMMENT_NOT_FOUND, Message: "Error: parent comment not found on this post"}
+				sendResponse(w, resp)
+				return
+			}
+			if parent.Depth+1 > commentMaxDepth {
+				resp.Error = &types.Error{Type: COMMENT_TOO_DEEP, Message: fmt.Sprintf("Error: replies can be nested at most %v levels deep", commentMaxDepth)}
+				sendResponse(w, resp)
+				return
+			}
+			if e := checkNotBlocked(user.Id, parent.User.(int)); e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+			comment.ParentId = &parent.Id
+			comment.Depth = parent.Depth + 1
+		}
+
+		//Store image after other input is valid
+		comment.Image, e = saveUploadedImage(r, "image")
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
 
 		num, err := db.SaveComment(comment)
 		if err != nil {
+			removeImage(comment.Image)
 			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save comment to database. %v", fmt.Sprint(err))}
 			sendResponse(w, resp)
 			return
 		}
 		resp.Payload = types.Inserted{Inserted: int(*num)}
 
+		indexContent(CONTENT_SOURCE_COMMENT, int(*num), user, content, date, postViewers(postId))
+
 	} else {
 		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
 		sendResponse(w, resp)
@@ -2430,10 +4925,104 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 	sendResponse(w, resp)
 }
 
+// PATCH /comments/{id} (content) by the author, DELETE /comments/{id} by the author or the post author.
+// Deleting a comment deletes replies to it
+func commentHandler(w http.ResponseWriter, r *http.Request, user *types.User) {
+	resp := types.Response{Payload: nil, Error: nil}
+
+	commentIdStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/comments"), "/")
+	commentId, err := strconv.Atoi(commentIdStr)
+	if err != nil || commentId < 1 {
+		resp.Error = &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: could parse: %v", commentIdStr)}
+		sendResponse(w, resp)
+		return
+	}
+
+	comment, err := db.GetCommentById(commentId)
+	if err != nil {
+		resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get comment from database. %v", err)}
+		sendResponse(w, resp)
+		return
+	}
+	if comment == nil {
+		resp.Error = &types.Error{Type: COMMENT_NOT_FOUND, Message: "Error: comment not found"}
+		sendResponse(w, resp)
+		return
+	}
+	isAuthor := comment.User.(int) == user.Id
+
+	if r.Method == "PATCH" {
+
+		if !isAuthor {
+			resp.Error = &types.Error{Type: AUTHORIZATION, Message: "Error: only the author can edit a comment"}
+			sendResponse(w, resp)
+			return
+		}
+
+		content, e := validateCommentContent(r.FormValue("content"))
+		if e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
+		date := util.GetCurrentMilli()
+		num, err := db.UpdateComment(commentId, content, date)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update comment in database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		resp.Payload = types.Updated{Updated: int(*num)}
+
+		indexContent(CONTENT_SOURCE_COMMENT, commentId, user, content, date, postViewers(comment.PostId))
+
+	} else if r.Method == "DELETE" {
+
+		if !isAuthor {
+			postAuthorId, err := db.GetPostAuthorId(comment.PostId)
+			if err != nil {
+				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get post from database. %v", err)}
+				sendResponse(w, resp)
+				return
+			}
+			if postAuthorId != user.Id {
+				resp.Error = &types.Error{Type: AUTHORIZATION, Message: "Error: only the author or the post author can delete a comment"}
+				sendResponse(w, resp)
+				return
+			}
+		}
+
+		num, images, err := db.DeleteComment(commentId)
+		if err != nil {
+			resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not delete comment from database. %v", err)}
+			sendResponse(w, resp)
+			return
+		}
+		for _, image := range images {
+			removeImage(image)
+		}
+		resp.Payload = types.RowsAffected{RowsAffected: int(num)}
+
+	} else {
+		resp.Error = &types.Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	sendResponse(w, resp)
+}
+
+func validateCommentContent(value string) (string, *types.Error) {
+	content := strings.TrimSpace(value)
+	if content == "" || len([]rune(content)) > commentMaxLength {
+		return "", &types.Error{Type: INVALID_COMMENT_FORMAT, Message: fmt.Sprintf("Error: comment shoud be between 1 and %v characters long", commentMaxLength)}
+	}
+	return content, nil
+}
+
 func eventsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_EVENTS)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
@@ -2526,6 +5115,12 @@ func eventsHandler(w http.ResponseWriter, r *http.Request) {
 
 	} else if r.Method == "POST" {
 
+		if e := requireVerifiedEmail(user); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
 		title := strings.TrimSpace(r.FormValue("title"))
 		if title == "" || len(title) > 50 {
 			resp.Error = &types.Error{Type: INVALID_EVENT_TITLE_FORMAT, Message: "Error: event title shoud be between 1 and 50 characters long"}
@@ -2659,7 +5254,7 @@ func eventsHandler(w http.ResponseWriter, r *http.Request) {
 			}
 			b, err := json.Marshal(swMessage)
 			if err == nil {
-				notifyClient(m.Id, b)
+				pushNotification(m.Id, user.Id, b)
 			} else {
 				fmt.Println(err)
 			}
@@ -2752,7 +5347,7 @@ func eventsHandler(w http.ResponseWriter, r *http.Request) {
 func chatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_CHAT)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
@@ -2947,6 +5542,12 @@ func chatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 	} else if r.Method == "POST" {
+		if e := requireVerifiedEmail(user); e != nil {
+			resp.Error = e
+			sendResponse(w, resp)
+			return
+		}
+
 		content := strings.TrimSpace(r.FormValue("message"))
 		if content == "" {
 			resp.Error = &types.Error{Type: MISSING_PARAM, Message: "Error: missing parameter in http request: message"}
@@ -2971,6 +5572,12 @@ func chatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			if e := checkNotBlocked(user.Id, recipientId); e != nil {
+				resp.Error = e
+				sendResponse(w, resp)
+				return
+			}
+
 			id, err := db.SavePrivateMessage(user.Id, recipientId, date, content)
 			if err != nil {
 				resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not save chat messages to database. %v", err)}
@@ -3001,6 +5608,11 @@ func chatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 				fmt.Println(err)
 			}
 
+			//Only the recipient can read a private message
+			indexContent(CONTENT_SOURCE_MESSAGE, *id, user, content, date, func(userId int) (bool, error) {
+				return userId == recipientId, nil
+			})
+
 			// id, err = UpdateInbox(*id, user.Id, &recipientId, nil, date)
 			// if err != nil {
 			// 	resp.Error = &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not update inbox. %v", err)}
@@ -3078,6 +5690,16 @@ func chatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			//Mentions notify members of the chat group only
+			indexContent(CONTENT_SOURCE_MESSAGE, *id, user, content, date, func(userId int) (bool, error) {
+				for _, memberId := range members {
+					if memberId == userId {
+						return true, nil
+					}
+				}
+				return false, nil
+			})
+
 			//Notify via ws
 			for _, memberId := range members {
 				swMessage := types.WSMessage{
@@ -3237,7 +5859,7 @@ func chatMessagesHandler(w http.ResponseWriter, r *http.Request) {
 func chatGroupsHandler(w http.ResponseWriter, r *http.Request) {
 	resp := types.Response{Payload: nil, Error: nil}
 
-	user, e := getUserFromRequest(r)
+	user, e := getUserFromRequestWithScope(r, SCOPE_CHAT)
 	if e != nil {
 		resp.Error = e
 		sendResponse(w, resp)
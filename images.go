@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	types "my-social-network/types"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Accepted image formats by detected content type
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// Reads image from multipart form field, checks size and format and stores it in IMAGES_DIRECTORY.
+// Returns name of the stored file, "" if the field is missing
+func saveUploadedImage(r *http.Request, field string) (string, *types.Error) {
+	file, _, err := r.FormFile(field)
+	if err != nil {
+		if err == http.ErrMissingFile {
+			return "", nil
+		}
+		if strings.Contains(err.Error(), "request body too large") {
+			return "", &types.Error{Type: IMAGE_TOO_LARGE, Message: fmt.Sprintf("Error: image should be less than %v bytes", imageMaxBytes)}
+		}
+		return "", &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: image error %v", err)}
+	}
+	defer file.Close()
+
+	fileBytes, err := io.ReadAll(io.LimitReader(file, int64(imageMaxBytes)+1))
+	if err != nil {
+		return "", &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while reading file %v", err)}
+	}
+	if len(fileBytes) > imageMaxBytes {
+		return "", &types.Error{Type: IMAGE_TOO_LARGE, Message: fmt.Sprintf("Error: image should be less than %v bytes", imageMaxBytes)}
+	}
+
+	extension, ok := imageExtensions[http.DetectContentType(fileBytes)]
+	if !ok {
+		return "", &types.Error{Type: INVALID_IMAGE_FORMAT, Message: "Error: image should be jpeg, png, gif or webp"}
+	}
+
+	err = makeDirectoryIfNotExists(IMAGES_DIRECTORY)
+	if err != nil {
+		return "", &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while creating directory %v", err)}
+	}
+
+	uuid := generateSessionId()
+	tempFile, err := os.CreateTemp(IMAGES_DIRECTORY, fmt.Sprintf("%v-*%v", uuid, extension))
+	if err != nil {
+		return "", &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while creating temp file %v", err)}
+	}
+	defer tempFile.Close()
+
+	_, err = tempFile.Write(fileBytes)
+	if err != nil {
+		os.Remove(tempFile.Name())
+		return "", &types.Error{Type: IMAGE_UPLOAD_ERROR, Message: fmt.Sprintf("Error: error while writing file %v", err)}
+	}
+
+	return filepath.Base(tempFile.Name()), nil
+}
+
+// Deletes image from IMAGES_DIRECTORY. Missing files are ignored
+func removeImage(name string) {
+	if name == "" {
+		return
+	}
+	err := os.Remove(filepath.Join(IMAGES_DIRECTORY, filepath.Base(name)))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error: could not delete image. ", err)
+	}
+}
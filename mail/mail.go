@@ -0,0 +1,67 @@
+package mail
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Outbound mail. Use SmtpMailer in production and SpoolMailer in dev and tests
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Sends mail through SMTP server with PLAIN auth (auth is skipped when Username is empty)
+type SmtpMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SmtpMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, buildMessage(m.From, to, subject, body))
+}
+
+// Writes every message as a separate .eml file into Directory instead of sending it
+type SpoolMailer struct {
+	Directory string
+	From      string
+}
+
+func (m *SpoolMailer) Send(to string, subject string, body string) error {
+	if err := os.MkdirAll(m.Directory, 0755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%v.eml", time.Now().UnixNano(), sanitize(to))
+	return os.WriteFile(filepath.Join(m.Directory, name), buildMessage(m.From, to, subject, body), 0644)
+}
+
+func buildMessage(from string, to string, subject string, body string) []byte {
+	headers := []string{
+		"From: " + from,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+	}
+	return []byte(strings.Join(headers, "\r\n") + "\r\n\r\n" + body + "\r\n")
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"my-social-network/mail"
+)
+
+var mailer = newMailer()
+
+func newMailer() mail.Mailer {
+	if mailDriver == "smtp" {
+		return &mail.SmtpMailer{
+			Host:     smtpHost,
+			Port:     smtpPort,
+			Username: smtpUsername,
+			Password: smtpPassword,
+			From:     mailFrom,
+		}
+	}
+	return &mail.SpoolMailer{Directory: mailSpoolDirectory, From: mailFrom}
+}
+
+// Sends mail in background. Errors are only logged
+func sendMail(to string, subject string, body string) {
+	go func() {
+		err := mailer.Send(to, subject, body)
+		if err != nil {
+			fmt.Println("Error: could not send mail to ", to, ". ", err)
+		}
+	}()
+}
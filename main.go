@@ -21,26 +21,59 @@ func main() {
 		}
 	}
 
+	go sweepSessions()
+	go sweepDeletedAccounts()
+	go sweepExports()
+
 	http.HandleFunc("/", rootHandler)
 	http.HandleFunc("/signin", signinHandler)
+	http.HandleFunc("/signin/2fa", signinTwoFactorHandler)
 	http.HandleFunc("/signup", signupHandler)
 	http.HandleFunc("/signout", signoutHandler)
+	http.HandleFunc("/sessions", sessionsHandler)
+	http.HandleFunc("/sessions/", sessionsHandler)
+	http.HandleFunc("/password", passwordHandler)
+	http.HandleFunc("/password/", passwordHandler)
+	http.HandleFunc("/email/", emailHandler)
+	http.HandleFunc("/security-events", securityEventsHandler)
+	http.HandleFunc("/2fa", twoFactorHandler)
+	http.HandleFunc("/2fa/", twoFactorHandler)
+	http.HandleFunc("/oidc/", oidcHandler)
+	http.HandleFunc("/tokens", apiTokensHandler)
+	http.HandleFunc("/tokens/", apiTokensHandler)
+	http.HandleFunc("/blocks", userRelationsHandler)
+	http.HandleFunc("/blocks/", userRelationsHandler)
+	http.HandleFunc("/mutes", userRelationsHandler)
+	http.HandleFunc("/mutes/", userRelationsHandler)
+	http.HandleFunc("/audience-lists", audienceListsHandler)
+	http.HandleFunc("/audience-lists/", audienceListsHandler)
+	http.HandleFunc("/export", exportHandler)
+	http.HandleFunc("/export/", exportHandler)
 	http.HandleFunc("/image", imageHandler)
 	http.HandleFunc("/users", usersHandler)
+	http.HandleFunc("/users/suggestions", usersHandler)
 	http.HandleFunc("/user/", userHandler)
 	http.HandleFunc("/user", userHandler)
+	http.HandleFunc("/user/avatar", userImageHandler)
+	http.HandleFunc("/user/cover", userImageHandler)
 	http.HandleFunc("/posts", postsHandler)
 	http.HandleFunc("/posts/", postsHandler)
+	http.HandleFunc("/feed", feedHandler)
+	http.HandleFunc("/hashtags/", hashtagsHandler)
+	http.HandleFunc("/reactions", reactionsHandler)
 	http.HandleFunc("/followers", followersHandler)
 	http.HandleFunc("/followers/", followersHandler)
 	http.HandleFunc("/following", followingHandler)
 	http.HandleFunc("/following/", followingHandler)
+	http.HandleFunc("/follow-requests", followRequestsHandler)
+	http.HandleFunc("/follow-requests/", followRequestsHandler)
 	http.HandleFunc("/notifications", notificationsHandler)
 	http.HandleFunc("/chatmessages", chatMessagesHandler)
 	http.HandleFunc("/chatmessages/", chatMessagesHandler)
 	http.HandleFunc("/groups", groupsHandler)
 	http.HandleFunc("/groups/", groupsHandler)
 	http.HandleFunc("/comments", commentsHandler)
+	http.HandleFunc("/comments/", commentsHandler)
 	http.HandleFunc("/events", eventsHandler)
 	http.HandleFunc("/events/", eventsHandler)
 	http.HandleFunc("/chatgroups", chatGroupsHandler)
@@ -48,6 +81,8 @@ func main() {
 
 	http.HandleFunc("/ws", wsHandler)
 
+	setupOidcProviders()
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	db "my-social-network/db/sqlite"
+	types "my-social-network/types"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// @nickname and #tag only count at the start of the content or after a character that cannot be
+// part of them, so e-mail addresses and html entities are left alone
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@#&])@([\p{L}\p{N}_][\p{L}\p{N}_.\-]*)`)
+var hashtagRegexp = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_@#&])#([\p{L}\p{N}_]+)`)
+
+// Distinct nicknames mentioned in the content, at most MENTIONS_MAX
+func parseMentions(content string) []string {
+	nickNames := []string{}
+	seen := map[string]bool{}
+	for _, match := range mentionRegexp.FindAllStringSubmatch(content, -1) {
+		//Trailing punctuation ends the sentence, not the nickname
+		nickName := strings.TrimRight(match[1], ".-")
+		key := strings.ToLower(nickName)
+		if nickName == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		nickNames = append(nickNames, nickName)
+		if len(nickNames) == MENTIONS_MAX {
+			break
+		}
+	}
+	return nickNames
+}
+
+// Distinct lower case hashtags of the content, without "#"
+func parseHashtags(content string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, match := range hashtagRegexp.FindAllStringSubmatch(content, -1) {
+		tag := normalizeHashtag(match[1])
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// Lower case tag without a leading "#". Returns "" if the value is not a valid hashtag:
+// letters, digits and underscores, at least one letter, at most HASHTAG_MAX_LENGTH characters
+func normalizeHashtag(value string) string {
+	tag := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value), "#"))
+	if tag == "" || utf8.RuneCountInString(tag) > HASHTAG_MAX_LENGTH {
+		return ""
+	}
+	hasLetter := false
+	for _, c := range tag {
+		if unicode.IsLetter(c) {
+			hasLetter = true
+		} else if !unicode.IsDigit(c) && c != '_' {
+			return ""
+		}
+	}
+	if !hasLetter {
+		return ""
+	}
+	return tag
+}
+
+// Indexes mentions and hashtags of saved or edited content. Users mentioned for the first time
+// get a notification if canSee tells they can see the content and no block is between them and the author.
+// Content is already saved, so errors are only logged
+func indexContent(sourceType string, sourceId int, author *types.User, content string, date int64, canSee func(userId int) (bool, error)) {
+	mentioned, err := db.SaveContentTags(sourceType, sourceId, author.Id, parseMentions(content), parseHashtags(content), date)
+	if err != nil {
+		fmt.Println("Error: could not save mentions and hashtags to database. ", err)
+		return
+	}
+
+	for _, userId := range mentioned {
+		blocked, err := db.IsBlockedBetween(author.Id, userId)
+		if err != nil {
+			fmt.Println("Error: could not get blocks from database for user ", userId, ". ", err)
+			continue
+		}
+		if blocked {
+			continue
+		}
+		visible, err := canSee(userId)
+		if err != nil {
+			fmt.Println("Error: could not check if mentioned user ", userId, " can see the ", sourceType, ". ", err)
+			continue
+		}
+		if visible {
+			notifyMention(author, userId, sourceType)
+		}
+	}
+}
+
+// Visibility check of indexContent for posts and comments on them
+func postViewers(postId int) func(userId int) (bool, error) {
+	return func(userId int) (bool, error) {
+		return db.CanViewPost(userId, postId)
+	}
+}
+
+func notifyMention(user *types.User, recipientId int, sourceType string) {
+	nick := user.FirstName + " " + user.LastName
+	if user.NickName != "" {
+		nick = user.NickName
+	}
+
+	n := types.Notification{
+		Type:      NOTIFICATION_MENTION,
+		Content:   fmt.Sprintf("%v mentioned you in a %v", nick, sourceType),
+		Sender:    types.UserBasicInfo{Id: user.Id, DisplayName: nick, Avatar: user.Avatar},
+		Recipient: recipientId,
+	}
+	err := db.SaveNotification(n)
+	if err != nil {
+		fmt.Println("Error: could not save a notification to database. ", err)
+		return
+	}
+
+	swMessage := types.WSMessage{
+		Type:    NEW_NOTIFICATION,
+		Payload: n,
+	}
+	b, err := json.Marshal(swMessage)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	pushNotification(recipientId, user.Id, b)
+}
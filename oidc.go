@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	db "my-social-network/db/sqlite"
+	"my-social-network/oidc"
+	types "my-social-network/types"
+	util "my-social-network/util"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+var oidcProviders = map[string]*oidc.Provider{}
+
+// Registers providers from configuration. Fake IdP is mounted on /fake-idp/
+func setupOidcProviders() {
+	for _, name := range strings.Split(oidcProviderNames, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer := strings.TrimSpace(os.Getenv(prefix + "ISSUER"))
+		clientId := strings.TrimSpace(os.Getenv(prefix + "CLIENT_ID"))
+		if issuer == "" || clientId == "" {
+			fmt.Printf("Skipping oidc provider %v: %vISSUER and %vCLIENT_ID are required\n", name, prefix, prefix)
+			continue
+		}
+		oidcProviders[name] = &oidc.Provider{
+			Name:         name,
+			Issuer:       issuer,
+			ClientId:     clientId,
+			ClientSecret: strings.TrimSpace(os.Getenv(prefix + "CLIENT_SECRET")),
+			RedirectUrl:  oidcRedirectUrl(name),
+		}
+	}
+
+	if oidcFakeIdpEnabled {
+		fakeIdp, err := oidc.NewFakeIdP(serverUrl+"/fake-idp", OIDC_FAKE_CLIENT_ID)
+		if err != nil {
+			fmt.Println("Error: could not start fake IdP. ", err)
+			return
+		}
+		http.Handle("/fake-idp/", http.StripPrefix("/fake-idp", fakeIdp))
+		oidcProviders[OIDC_FAKE_PROVIDER] = &oidc.Provider{
+			Name:        OIDC_FAKE_PROVIDER,
+			Issuer:      fakeIdp.Issuer,
+			ClientId:    OIDC_FAKE_CLIENT_ID,
+			RedirectUrl: oidcRedirectUrl(OIDC_FAKE_PROVIDER),
+			HttpClient:  fakeIdp.Client(),
+		}
+		fmt.Println("Fake IdP enabled. Do not use in production")
+	}
+}
+
+func oidcRedirectUrl(provider string) string {
+	return serverUrl + "/oidc/callback/" + provider
+}
+
+func getOidcProviderNames() []string {
+	names := []string{}
+	for name := range oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Starts authorization code flow. userId is set when linking identity to signed in user
+func startOidcFlow(w http.ResponseWriter, provider *oidc.Provider, userId int) (string, error) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	browserSecret, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	codeVerifier, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+
+	authorizationUrl, err := provider.AuthCodeURL(state, nonce, oidc.CodeChallenge(codeVerifier))
+	if err != nil {
+		return "", err
+	}
+
+	now := util.GetCurrentMilli()
+	err = db.SaveOidcState(types.OidcState{
+		Provider:     provider.Name,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		UserId:       userId,
+		BrowserHash:  util.HashToken(browserSecret),
+		CreatedAt:    now,
+		ExpiresAt:    now + oidcStateTTL.Milliseconds(),
+	}, util.HashToken(state))
+	if err != nil {
+		return "", err
+	}
+
+	//The callback only accepts the state in the browser that started the flow
+	setOidcBrowserCookie(w, browserSecret, int(oidcStateTTL.Seconds()))
+	return authorizationUrl, nil
+}
+
+// Lax, not Strict: the provider sends the browser to the callback with a cross-site redirect
+func setOidcBrowserCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     OIDC_BROWSER_COOKIE_NAME,
+		Value:    value,
+		Path:     "/oidc/callback/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   sessionCookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// True if the request carries the browser cookie set when the state was created
+func isOidcStateBrowser(r *http.Request, state *types.OidcState) bool {
+	cookie, err := r.Cookie(OIDC_BROWSER_COOKIE_NAME)
+	if err != nil || cookie.Value == "" || state.BrowserHash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(util.HashToken(cookie.Value)), []byte(state.BrowserHash)) == 1
+}
+
+// Creates account for first sign in with external identity. Claims have to pass signup rules
+func provisionOidcUser(claims *oidc.Claims) (*types.User, *types.Error) {
+	firstName := strings.TrimSpace(claims.GivenName)
+	lastName := strings.TrimSpace(claims.FamilyName)
+	nickName := strings.TrimSpace(claims.PreferredUsername)
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+
+	milli, e := validateSignupDetails(firstName, lastName, nickName, claims.Birthdate, email)
+	if e != nil {
+		return nil, e
+	}
+
+	existing, err := db.GetUserByEmail(email)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: cannot get user from database. %v", err)}
+	}
+	//Linking to existing account has to be done by its owner after signing in
+	if existing != nil {
+		return nil, &types.Error{Type: ACCOUNT_EXISTS, Message: "Error: account with this email exists. Sign in and link the provider"}
+	}
+
+	user := types.User{
+		FirstName:   firstName,
+		LastName:    lastName,
+		NickName:    nickName,
+		DateOfBirth: milli,
+		Email:       email,
+		Privacy:     "public",
+	}
+	id, err := db.SaveUser(&user)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: cannot save user. %v", err)}
+	}
+	user.Id = int(id)
+
+	if claims.EmailVerified {
+		err = db.SetEmailVerified(user.Id, true)
+		if err != nil {
+			return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: cannot update user. %v", err)}
+		}
+		user.EmailVerified = true
+	} else {
+		err = sendVerificationEmail(&user)
+		if err != nil {
+			fmt.Println("Error: could not send verification email. ", err)
+		}
+	}
+	return &user, nil
+}
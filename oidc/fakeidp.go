@@ -0,0 +1,229 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal OpenID provider for development and offline testing. It signs in
+// anyone without asking: identity is taken from query parameters of the
+// authorization request (login_hint is the email, given_name, family_name,
+// preferred_username and birthdate are optional). Never enable in production
+type FakeIdP struct {
+	Issuer   string
+	ClientId string
+
+	key   *rsa.PrivateKey
+	mutex sync.Mutex
+	codes map[string]fakeAuthorization
+}
+
+type fakeAuthorization struct {
+	redirectUri   string
+	codeChallenge string
+	claims        Claims
+	expiresAt     time.Time
+}
+
+const fakeKeyId = "fake-idp-key"
+
+func NewFakeIdP(issuer string, clientId string) (*FakeIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &FakeIdP{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientId: clientId,
+		key:      key,
+		codes:    map[string]fakeAuthorization{},
+	}, nil
+}
+
+// Http client that serves requests to the fake IdP in-process, without network
+func (f *FakeIdP) Client() *http.Client {
+	return &http.Client{Transport: f}
+}
+
+func (f *FakeIdP) RoundTrip(r *http.Request) (*http.Response, error) {
+	recorder := httptest.NewRecorder()
+	request := r.Clone(r.Context())
+	request.URL.Path = strings.TrimPrefix(r.URL.Path, issuerPath(f.Issuer))
+	f.ServeHTTP(recorder, request)
+	return recorder.Result(), nil
+}
+
+func issuerPath(issuer string) string {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// Paths are relative to issuer (mount with http.StripPrefix)
+func (f *FakeIdP) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJson(w, http.StatusOK, discovery{
+			Issuer:                f.Issuer,
+			AuthorizationEndpoint: f.Issuer + "/authorize",
+			TokenEndpoint:         f.Issuer + "/token",
+			JwksUri:               f.Issuer + "/jwks",
+		})
+	case "/authorize":
+		f.authorize(w, r)
+	case "/token":
+		f.token(w, r)
+	case "/jwks":
+		writeJson(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": fakeKeyId,
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+			}},
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *FakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectUri := q.Get("redirect_uri")
+
+	if q.Get("client_id") != f.ClientId || redirectUri == "" {
+		http.Error(w, "invalid client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(q.Get("login_hint")))
+	if email == "" {
+		email = "fake.user@example.com"
+	}
+	claims := Claims{
+		Issuer:            f.Issuer,
+		Subject:           "fake|" + email,
+		Audience:          f.ClientId,
+		Nonce:             q.Get("nonce"),
+		Email:             email,
+		EmailVerified:     true,
+		GivenName:         valueOr(q.Get("given_name"), "Fake"),
+		FamilyName:        valueOr(q.Get("family_name"), "User"),
+		PreferredUsername: q.Get("preferred_username"),
+		Birthdate:         valueOr(q.Get("birthdate"), "1990-01-01"),
+	}
+
+	code, err := RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f.mutex.Lock()
+	f.codes[code] = fakeAuthorization{
+		redirectUri:   redirectUri,
+		codeChallenge: q.Get("code_challenge"),
+		claims:        claims,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	f.mutex.Unlock()
+
+	params := url.Values{}
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	http.Redirect(w, r, redirectUri+"?"+params.Encode(), http.StatusFound)
+}
+
+func (f *FakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.FormValue("code")
+
+	//Codes are single use
+	f.mutex.Lock()
+	authorization, ok := f.codes[code]
+	delete(f.codes, code)
+	f.mutex.Unlock()
+
+	if !ok || time.Now().After(authorization.expiresAt) ||
+		r.FormValue("grant_type") != "authorization_code" ||
+		r.FormValue("client_id") != f.ClientId ||
+		r.FormValue("redirect_uri") != authorization.redirectUri ||
+		CodeChallenge(r.FormValue("code_verifier")) != authorization.codeChallenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := authorization.claims
+	claims.IssuedAt = now.Unix()
+	claims.Expiry = now.Add(5 * time.Minute).Unix()
+
+	idToken, err := f.sign(claims)
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	accessToken, _ := RandomString()
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (f *FakeIdP) sign(claims Claims) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": fakeKeyId})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func valueOr(value string, defaultValue string) string {
+	if strings.TrimSpace(value) == "" {
+		return defaultValue
+	}
+	return strings.TrimSpace(value)
+}
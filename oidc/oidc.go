@@ -0,0 +1,335 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect relying party: authorization code flow with PKCE (S256)
+// and RS256 signed ID tokens
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+	HttpClient   *http.Client
+
+	mutex     sync.Mutex
+	discovery *discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// ID token claims used for linking and provisioning
+type Claims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          interface{} `json:"aud"`
+	Expiry            int64       `json:"exp"`
+	IssuedAt          int64       `json:"iat"`
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	EmailVerified     bool        `json:"email_verified"`
+	GivenName         string      `json:"given_name"`
+	FamilyName        string      `json:"family_name"`
+	PreferredUsername string      `json:"preferred_username"`
+	Birthdate         string      `json:"birthdate"`
+}
+
+// Allowed clock difference when checking token times
+const clockSkew = time.Minute
+
+func (p *Provider) client() *http.Client {
+	if p.HttpClient != nil {
+		return p.HttpClient
+	}
+	return http.DefaultClient
+}
+
+// Fetches and caches provider metadata from /.well-known/openid-configuration
+func (p *Provider) getDiscovery() (*discovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	resp, err := p.client().Get(strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discovery failed with status %v", resp.StatusCode)
+	}
+
+	d := discovery{}
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	if err != nil {
+		return nil, err
+	}
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("issuer mismatch: %v", d.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JwksUri == "" {
+		return nil, errors.New("incomplete provider metadata")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// URL of the provider's login page the browser is sent to
+func (p *Provider) AuthCodeURL(state string, nonce string, codeChallenge string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.ClientId)
+	params.Set("redirect_uri", p.RedirectUrl)
+	params.Set("scope", strings.Join(scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Redeems authorization code and returns verified claims of the ID token
+func (p *Provider) Exchange(code string, codeVerifier string, nonce string) (*Claims, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectUrl)
+	form.Set("client_id", p.ClientId)
+	form.Set("code_verifier", codeVerifier)
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	resp, err := p.client().PostForm(d.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token request failed with status %v", resp.StatusCode)
+	}
+
+	token := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+	if token.IdToken == "" {
+		return nil, errors.New("no id_token in token response")
+	}
+
+	claims, err := p.verifyIdToken(token.IdToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	return claims, nil
+}
+
+func (p *Provider) verifyIdToken(idToken string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	err = json.Unmarshal(headerBytes, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported id_token algorithm: %v", header.Alg)
+	}
+
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, errors.New("invalid id_token signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	claims := Claims{}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if claims.Issuer != p.Issuer {
+		return nil, errors.New("id_token issuer mismatch")
+	}
+	if !hasAudience(claims.Audience, p.ClientId) {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	if time.Unix(claims.Expiry, 0).Add(clockSkew).Before(now) {
+		return nil, errors.New("id_token has expired")
+	}
+	if time.Unix(claims.IssuedAt, 0).Add(-clockSkew).After(now) {
+		return nil, errors.New("id_token issued in the future")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id_token has no subject")
+	}
+	return &claims, nil
+}
+
+func hasAudience(audience interface{}, clientId string) bool {
+	switch aud := audience.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns signing key by id. Keys are refetched once when kid is unknown (key rotation)
+func (p *Provider) getKey(kid string) (*rsa.PublicKey, error) {
+	p.mutex.Lock()
+	key, ok := p.keys[kid]
+	p.mutex.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.client().Get(d.JwksUri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&jwks)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %v", kid)
+	}
+	return key, nil
+}
+
+// Random url-safe string for state, nonce and code verifier
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// S256 code challenge of PKCE code verifier
+func CodeChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	types "my-social-network/types"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Cursors are opaque to clients: base64 of the json encoded types.Cursor
+func encodeCursor(cursor types.Cursor) string {
+	b, err := json.Marshal(cursor)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string) (*types.Cursor, *types.Error) {
+	if value == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, &types.Error{Type: INVALID_CURSOR, Message: "Error: invalid cursor"}
+	}
+	cursor := types.Cursor{}
+	err = json.Unmarshal(b, &cursor)
+	if err != nil {
+		return nil, &types.Error{Type: INVALID_CURSOR, Message: "Error: invalid cursor"}
+	}
+	return &cursor, nil
+}
+
+// Reads "cursor" and "limit" query parameters. Limit defaults to PAGE_SIZE_DEFAULT
+func getPageParams(r *http.Request) (*types.Cursor, int, *types.Error) {
+	cursor, e := decodeCursor(strings.TrimSpace(r.URL.Query().Get("cursor")))
+	if e != nil {
+		return nil, 0, e
+	}
+
+	limit := PAGE_SIZE_DEFAULT
+	if value := strings.TrimSpace(r.URL.Query().Get("limit")); value != "" {
+		l, err := strconv.Atoi(value)
+		if err != nil || l < 1 || l > PAGE_SIZE_MAX {
+			return nil, 0, &types.Error{Type: PARSE_ERROR, Message: fmt.Sprintf("Error: limit should be between 1 and %v", PAGE_SIZE_MAX)}
+		}
+		limit = l
+	}
+	return cursor, limit, nil
+}
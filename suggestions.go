@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	db "my-social-network/db/sqlite"
+	types "my-social-network/types"
+	"sort"
+)
+
+// Ranks users the caller may know. Only basic info is returned, so private accounts
+// show no more than they do to any other user
+func suggestFollows(userId int, limit int) ([]types.Suggestion, error) {
+	signals, err := db.GetSuggestionSignals(userId)
+	if err != nil {
+		return nil, err
+	}
+
+	suggestions := []types.Suggestion{}
+	for id, s := range signals {
+		score := s.MutualFollows*SUGGESTION_WEIGHT_MUTUAL_FOLLOW +
+			s.SharedGroups*SUGGESTION_WEIGHT_GROUP +
+			s.SharedEvents*SUGGESTION_WEIGHT_EVENT +
+			s.SharedChatGroups*SUGGESTION_WEIGHT_CHAT_GROUP
+		if score == 0 {
+			continue
+		}
+		suggestions = append(suggestions, types.Suggestion{
+			User:   types.UserBasicInfo{Id: id},
+			Reason: suggestionReason(s),
+			Score:  score,
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].User.Id < suggestions[j].User.Id
+	})
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	for i, suggestion := range suggestions {
+		info := ToUserBasicInfo(suggestion.User.Id)
+		if info != nil {
+			suggestions[i].User = *info
+		}
+	}
+	return suggestions, nil
+}
+
+// Describes the strongest signal
+func suggestionReason(s *types.SuggestionSignals) string {
+	reasons := []struct {
+		weight int
+		text   string
+	}{
+		{s.MutualFollows * SUGGESTION_WEIGHT_MUTUAL_FOLLOW, fmt.Sprintf("Followed by %v %v you follow", s.MutualFollows, plural(s.MutualFollows, "person", "people"))},
+		{s.SharedGroups * SUGGESTION_WEIGHT_GROUP, fmt.Sprintf("%v shared %v", s.SharedGroups, plural(s.SharedGroups, "group", "groups"))},
+		{s.SharedEvents * SUGGESTION_WEIGHT_EVENT, fmt.Sprintf("Going to %v %v with you", s.SharedEvents, plural(s.SharedEvents, "event", "events"))},
+		{s.SharedChatGroups * SUGGESTION_WEIGHT_CHAT_GROUP, fmt.Sprintf("%v shared chat %v", s.SharedChatGroups, plural(s.SharedChatGroups, "group", "groups"))},
+	}
+	best := reasons[0]
+	for _, r := range reasons[1:] {
+		if r.weight > best.weight {
+			best = r
+		}
+	}
+	return best.text
+}
+
+func plural(n int, one string, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
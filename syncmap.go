@@ -5,35 +5,93 @@ import (
 	"sync"
 )
 
+// Connected clients by user id and session id. One user can be connected
+// from several devices at the same time
 type mapStruct struct {
 	sync.Mutex
-	clients map[int]*Client
+	clients map[int]map[string]*Client
 }
 
-func (m *mapStruct) Get(id int) *Client {
+func (m *mapStruct) Get(id int) []*Client {
 	m.Lock()
 	defer m.Unlock()
 
-	if v, ok := m.clients[id]; ok {
-		return v
+	result := []*Client{}
+	if sessions, ok := m.clients[id]; ok {
+		for _, client := range sessions {
+			result = append(result, client)
+		}
 	}
-	return nil
+	return result
+}
+
+// Ids of connected users
+func (m *mapStruct) UserIds() []int {
+	m.Lock()
+	defer m.Unlock()
+
+	result := []int{}
+	for id := range m.clients {
+		result = append(result, id)
+	}
+	return result
 }
 
 func (m *mapStruct) Set(id int, client *Client) {
 	m.Lock()
 	defer m.Unlock()
-	m.clients[id] = client
+	if _, ok := m.clients[id]; !ok {
+		m.clients[id] = make(map[string]*Client)
+	}
+	if existing, ok := m.clients[id][client.sessionId]; ok && existing != client {
+		existing.conn.Close()
+	}
+	m.clients[id][client.sessionId] = client
 	fmt.Println("Client with Id ", id, " added.")
 	fmt.Println("Clients: ", m.clients)
 }
 
-func (m *mapStruct) Delete(id int) {
+func (m *mapStruct) Delete(client *Client) {
+	m.Lock()
+	defer m.Unlock()
+	id := client.user.Id
+	if sessions, ok := m.clients[id]; ok {
+		if c, ok := sessions[client.sessionId]; ok && c == client {
+			delete(sessions, client.sessionId)
+			client.conn.Close()
+			if len(sessions) == 0 {
+				delete(m.clients, id)
+			}
+			fmt.Printf("Deleted: %v\n", id)
+			fmt.Println("Clients: ", m.clients)
+		}
+	}
+}
+
+func (m *mapStruct) DeleteBySessionId(id int, sessionId string) {
+	m.Lock()
+	defer m.Unlock()
+	if sessions, ok := m.clients[id]; ok {
+		if client, ok := sessions[sessionId]; ok {
+			delete(sessions, sessionId)
+			client.conn.Close()
+			if len(sessions) == 0 {
+				delete(m.clients, id)
+			}
+			fmt.Printf("Deleted: %v\n", id)
+			fmt.Println("Clients: ", m.clients)
+		}
+	}
+}
+
+func (m *mapStruct) DeleteAll(id int) {
 	m.Lock()
 	defer m.Unlock()
-	if client, ok := m.clients[id]; ok {
+	if sessions, ok := m.clients[id]; ok {
 		delete(m.clients, id)
-		client.conn.Close()
+		for _, client := range sessions {
+			client.conn.Close()
+		}
 		fmt.Printf("Deleted: %v\n", id)
 		fmt.Println("Clients: ", m.clients)
 	}
@@ -41,6 +99,6 @@ func (m *mapStruct) Delete(id int) {
 
 func MakeMap() mapStruct {
 	return mapStruct{
-		clients: make(map[int]*Client),
+		clients: make(map[int]map[string]*Client),
 	}
 }
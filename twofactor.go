@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	db "my-social-network/db/sqlite"
+	"my-social-network/totp"
+	types "my-social-network/types"
+	util "my-social-network/util"
+	"strings"
+	"time"
+)
+
+// Checks TOTP code or one of the recovery codes. Each TOTP step and each recovery code
+// is accepted only once
+func verifyTwoFactorCode(twoFactor *types.TwoFactor, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+
+	step, ok := totp.Validate(twoFactor.Secret, code, time.Now(), TOTP_SKEW_STEPS)
+	if ok {
+		num, err := db.UseTotpStep(twoFactor.UserId, step)
+		if err != nil {
+			return false, err
+		}
+		return *num > 0, nil
+	}
+
+	if !twoFactor.Enabled {
+		return false, nil
+	}
+
+	codes, err := db.GetUnusedRecoveryCodes(twoFactor.UserId)
+	if err != nil {
+		return false, err
+	}
+	normalized := normalizeRecoveryCode(code)
+	for _, recoveryCode := range *codes {
+		if util.CompairPasswords(recoveryCode.CodeHash, normalized) {
+			num, err := db.UseRecoveryCode(recoveryCode.Id, util.GetCurrentMilli())
+			if err != nil {
+				return false, err
+			}
+			return *num > 0, nil
+		}
+	}
+	return false, nil
+}
+
+// Generates new set of recovery codes replacing old ones. Plain codes are returned
+// to be shown once, database keeps bcrypt hashes only
+func generateRecoveryCodes(userId int) ([]string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < RECOVERY_CODES_COUNT; i++ {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, util.Encrypt(code))
+	}
+
+	err := db.SaveRecoveryCodes(userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// Creates short-lived token for the second signin step
+func createTwoFactorChallenge(userId int) (*types.SigninChallenge, error) {
+	token, err := generateSecureToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := util.GetCurrentMilli()
+	expiresAt := now + twoFactorChallengeTTL.Milliseconds()
+	err = db.SaveTwoFactorChallenge(userId, util.HashToken(token), now, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SigninChallenge{TwoFactorRequired: true, ChallengeToken: token, ExpiresAt: expiresAt}, nil
+}
+
+// Returns the 2FA settings of user if 2FA is enabled
+func getEnabledTwoFactor(userId int) (*types.TwoFactor, *types.Error) {
+	twoFactor, err := db.GetTwoFactor(userId)
+	if err != nil {
+		return nil, &types.Error{Type: DATABASE_ERROR, Message: fmt.Sprintf("Error: could not get two factor settings from database. %v", err)}
+	}
+	if twoFactor == nil || !twoFactor.Enabled {
+		return nil, nil
+	}
+	return twoFactor, nil
+}
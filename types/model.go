@@ -15,16 +15,39 @@ type Echo struct {
 }
 
 type User struct {
-	Id          int    `json:"id"`
-	FirstName   string `json:"first_name"`
-	LastName    string `json:"last_name"`
-	NickName    string `json:"nick_name"`
-	DateOfBirth int64  `json:"date_of_birth"`
-	Email       string `json:"email"`
-	Password    string `json:"-"`
-	AboutMe     string `json:"about_me"`
-	Avatar      string `json:"avatar"`
-	Privacy     string `json:"privacy"`
+	Id                  int    `json:"id"`
+	FirstName           string `json:"first_name"`
+	LastName            string `json:"last_name"`
+	NickName            string `json:"nick_name"`
+	DateOfBirth         int64  `json:"date_of_birth"`
+	Email               string `json:"email"`
+	Password            string `json:"-"`
+	AboutMe             string `json:"about_me"`
+	Avatar              string `json:"avatar"`
+	Cover               string `json:"cover"`
+	Privacy             string `json:"privacy"`
+	EmailVerified       bool   `json:"email_verified"`
+	DeletionScheduledAt int64  `json:"deletion_scheduled_at,omitempty"`
+
+	// Who can see email, date of birth and about me: "public", "followers" or "private".
+	// Only sent to the owner
+	EmailVisibility       string `json:"email_visibility,omitempty"`
+	DateOfBirthVisibility string `json:"date_of_birth_visibility,omitempty"`
+	AboutMeVisibility     string `json:"about_me_visibility,omitempty"`
+}
+
+// PATCH /user. Nil fields are left unchanged
+type ProfileUpdate struct {
+	FirstName             *string
+	LastName              *string
+	NickName              *string
+	DateOfBirth           *int64
+	Email                 *string
+	AboutMe               *string
+	Privacy               *string
+	EmailVisibility       *string
+	DateOfBirthVisibility *string
+	AboutMeVisibility     *string
 }
 
 type UserBasicInfo struct {
@@ -33,19 +56,276 @@ type UserBasicInfo struct {
 	Avatar      string `json:"avatar"`
 }
 
+// Position after the last item of a page. Value is the sort key of that item
+type Cursor struct {
+	Value string `json:"v"`
+	Id    int    `json:"id"`
+}
+
+// GET /users
+type UserQuery struct {
+	Text  string
+	Sort  string
+	After *Cursor
+	Limit int
+}
+
+// User in search results with follow status relative to the caller
+type UserSearchResult struct {
+	UserBasicInfo
+	Following  bool `json:"following"`
+	Pending    bool `json:"pending"`
+	FollowsYou bool `json:"follows_you"`
+
+	//Lowercased display name as compared by the database, for cursors
+	SortName string `json:"-"`
+}
+
+type UserPage struct {
+	Users      []UserSearchResult `json:"users"`
+	NextCursor string             `json:"next_cursor,omitempty"`
+}
+
+// Blocked or muted user
+type UserRelation struct {
+	User UserBasicInfo `json:"user"`
+	Date int64         `json:"date"`
+}
+
+// Named list of users a post can be shared with, e.g. "Close friends"
+type AudienceList struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name"`
+	Date        int64          `json:"created_at"`
+	MemberCount int            `json:"member_count"`
+	Members     []UserRelation `json:"members,omitempty"`
+}
+
+type SuggestionSignals struct {
+	MutualFollows    int
+	SharedGroups     int
+	SharedEvents     int
+	SharedChatGroups int
+}
+
+// GET /users/suggestions
+type Suggestion struct {
+	User   UserBasicInfo `json:"user"`
+	Reason string        `json:"reason"`
+	Score  int           `json:"score"`
+}
+
+// Sent over ws when a follow request is approved, rejected or cancelled
+type FollowRequestUpdate struct {
+	FollowerId int    `json:"follower_id"`
+	FolloweeId int    `json:"followee_id"`
+	Status     string `json:"status"`
+}
+
 type Session struct {
 	SessionId string `json:"session_id"`
 }
 
+type UserSession struct {
+	Id        int    `json:"id"`
+	SessionId string `json:"-"`
+	UserId    int    `json:"-"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	ExpiresAt int64  `json:"expires_at"`
+	UserAgent string `json:"user_agent"`
+	Ip        string `json:"ip"`
+	Revoked   bool   `json:"-"`
+	Current   bool   `json:"current"`
+}
+
+type PasswordResetToken struct {
+	Id        int
+	UserId    int
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+type EmailVerificationToken struct {
+	Id        int
+	UserId    int
+	Email     string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    int64
+}
+
+type SecurityEvent struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"-"`
+	Type      string `json:"type"`
+	Ip        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Date      int64  `json:"date"`
+}
+
+type TwoFactor struct {
+	UserId    int
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+	CreatedAt int64
+	EnabledAt int64
+}
+
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+type RecoveryCode struct {
+	Id       int
+	UserId   int
+	CodeHash string
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type TwoFactorChallenge struct {
+	Id        int
+	UserId    int
+	CreatedAt int64
+	ExpiresAt int64
+	Attempts  int
+}
+
+// Returned by signin instead of session when account has 2FA enabled
+type SigninChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresAt         int64  `json:"expires_at"`
+}
+
+type ExternalIdentity struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"-"`
+	Provider  string `json:"provider"`
+	Subject   string `json:"-"`
+	Email     string `json:"email"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+type OidcState struct {
+	Id           int
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	UserId       int
+	BrowserHash  string
+	CreatedAt    int64
+	ExpiresAt    int64
+}
+
+type AuthorizationUrl struct {
+	AuthorizationUrl string `json:"authorization_url"`
+}
+
+type ApiToken struct {
+	Id         int      `json:"id"`
+	UserId     int      `json:"-"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+}
+
+// Plain token is returned only once, when it is created
+type CreatedApiToken struct {
+	Token    string    `json:"token"`
+	ApiToken *ApiToken `json:"api_token"`
+}
+
+type AccountDeletion struct {
+	DeletionScheduledAt int64 `json:"deletion_scheduled_at"`
+}
+
+// Background job, e.g. personal data export. File is served by the job's own endpoint
+type Job struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"-"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	File      string `json:"-"`
+	Error     string `json:"error"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
 type Post struct {
-	Id       int         `json:"id"`
-	Date     int64       `json:"date"`
-	Group    interface{} `json:"group"`
-	Content  string      `json:"content"`
-	Privacy  string      `json:"privacy"`
-	Image    string      `json:"image"`
-	User     User        `json:"user"`
-	Comments []Comment   `json:"comments"`
+	Id            int         `json:"id"`
+	Date          int64       `json:"date"`
+	Group         interface{} `json:"group"`
+	Content       string      `json:"content"`
+	Privacy       string      `json:"privacy"`
+	Image         string      `json:"image"`
+	User          User        `json:"user"`
+	Comments      []Comment   `json:"comments"`
+	Audience      []int       `json:"audience,omitempty"`
+	AudienceLists []int       `json:"audience_lists,omitempty"`
+	EditedAt      int64       `json:"edited_at,omitempty"`
+}
+
+// Filters of the home feed. Zero values are not applied.
+// Since and Until bound the post date (exclusive and inclusive)
+type FeedQuery struct {
+	PostId  int
+	Hashtag string
+	Since   int64
+	Until   int64
+	After   *Cursor
+	Limit   int
+}
+
+// Hashtag used in posts and comments within the trending window.
+// Authors is the number of distinct users who used it
+type HashtagTrend struct {
+	Tag      string `json:"tag"`
+	Uses     int    `json:"uses"`
+	Authors  int    `json:"authors"`
+	LastUsed int64  `json:"last_used"`
+}
+
+// Feed post with the newest comments in Comments, the total number of comments and reactions
+type FeedPost struct {
+	Post
+	CommentCount int              `json:"comment_count"`
+	Reactions    *ReactionSummary `json:"reactions"`
+}
+
+// Sent over ws when a new post enters the user's feed
+type NewPostsAvailable struct {
+	PostId   int `json:"post_id"`
+	AuthorId int `json:"author_id"`
+}
+
+type FeedPage struct {
+	Posts      []FeedPost `json:"posts"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// Previous version of a post. Audience holds user ids of specific posts or list ids of audience posts
+type PostEdit struct {
+	Id       int    `json:"id"`
+	EditorId int    `json:"editor_id"`
+	Content  string `json:"content"`
+	Privacy  string `json:"privacy"`
+	Audience []int  `json:"audience"`
+	Date     int64  `json:"date"`
 }
 
 type GroupPost struct {
@@ -56,6 +336,7 @@ type GroupPost struct {
 	Image    string    `json:"image"`
 	User     User      `json:"user"`
 	Comments []Comment `json:"comments"`
+	EditedAt int64     `json:"edited_at,omitempty"`
 }
 
 type HomePageData struct {
@@ -75,16 +356,24 @@ type Followers struct {
 	Followers []int `json:"followers"`
 }
 
-type Follower struct {
-	Follower *User `json:"follower"`
-	Date     int64 `json:"date"`
-	Approved bool  `json:"approved"`
+// Follower or followed user. Mutual is set when the two follow each other
+type FollowEdge struct {
+	Id       int           `json:"-"`
+	User     UserBasicInfo `json:"user"`
+	Date     int64         `json:"date"`
+	Approved bool          `json:"approved"`
+	Mutual   bool          `json:"mutual"`
+}
+
+type FollowPage struct {
+	Users      []FollowEdge `json:"users"`
+	NextCursor string       `json:"next_cursor,omitempty"`
 }
 
-type Following struct {
-	Following *User `json:"following"`
-	Date      int64 `json:"date"`
-	Approved  bool  `json:"approved"`
+// Approved follows only
+type FollowCounts struct {
+	Followers int `json:"followers"`
+	Following int `json:"following"`
 }
 
 type Notification struct {
@@ -97,6 +386,9 @@ type Notification struct {
 	IsRead    bool        `json:"is_read"`
 	Group     interface{} `json:"group"`
 	Event     interface{} `json:"event"`
+
+	// Follow requests: pending, approved, rejected or cancelled. Only pending ones can be acted on
+	ActionStatus string `json:"action_status,omitempty"`
 }
 
 type WSMessage struct {
@@ -200,13 +492,31 @@ type JoinRequest struct {
 	MemberId int
 }
 
+// Comment or, with ParentId set, a reply. Top level comments have depth 0
 type Comment struct {
-	Id      int         `json:"id"`
-	Date    int64       `json:"date"`
-	User    interface{} `json:"user"`
-	PostId  int         `json:"post_id"`
-	Content string      `json:"content"`
-	Image   string      `json:"image"`
+	Id         int              `json:"id"`
+	Date       int64            `json:"date"`
+	User       interface{}      `json:"user"`
+	PostId     int              `json:"post_id"`
+	Content    string           `json:"content"`
+	Image      string           `json:"image"`
+	ParentId   *int             `json:"parent_id"`
+	Depth      int              `json:"depth"`
+	ReplyCount int              `json:"reply_count"`
+	EditedAt   int64            `json:"edited_at,omitempty"`
+	Reactions  *ReactionSummary `json:"reactions,omitempty"`
+}
+
+type CommentPage struct {
+	Comments   []Comment `json:"comments"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Reaction counts by type. Mine is the caller's own reaction, "" if none
+type ReactionSummary struct {
+	Counts map[string]int `json:"counts"`
+	Total  int            `json:"total"`
+	Mine   string         `json:"mine"`
 }
 
 type Event struct {
@@ -1,7 +1,8 @@
 package util
 
 import (
-
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -26,3 +27,9 @@ func GetCurrentMilli() int64 {
 	return time.Now().UnixNano() / 1000000
 }
 
+
+// Function hashes token for lookup in database. Tokens are random, so no salt is needed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
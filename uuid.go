@@ -1,9 +1,22 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+
 	"github.com/google/uuid"
 )
 
 func generateSessionId() string {
 	return uuid.New().String()
 }
+
+// Random token for links sent by email. Only its hash is stored in database
+func generateSecureToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
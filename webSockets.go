@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	db "my-social-network/db/sqlite"
 	types "my-social-network/types"
 	"net/http"
 
@@ -10,6 +11,7 @@ import (
 
 type Client struct {
 	user           *types.User
+	sessionId      string
 	conn           *websocket.Conn
 	messageChannel chan []byte
 }
@@ -19,10 +21,17 @@ var clients = MakeMap()
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin:     func(r *http.Request) bool { return true },
+	CheckOrigin:     checkOrigin,
 }
 
-func addClient(user types.User, w http.ResponseWriter, r *http.Request) {
+// Browsers always send Origin with websocket handshakes. Since /ws authenticates from the cookie,
+// only the client app may open sockets; clients without Origin are not browsers
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || origin == clientOrigin
+}
+
+func addClient(user types.User, sessionId string, w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		fmt.Println(err)
@@ -30,49 +39,49 @@ func addClient(user types.User, w http.ResponseWriter, r *http.Request) {
 	}
 	client := Client{
 		user:           &user,
+		sessionId:      sessionId,
 		conn:           ws,
 		messageChannel: make(chan []byte),
 	}
 
 	clients.Set(user.Id, &client)
 
-	go writeMessage(user.Id)
-	go readMessages(user.Id)
+	go writeMessage(&client)
+	go readMessages(&client)
 }
 
-func removeClient(id int) {
-	clients.Delete(id)
+func removeClient(client *Client) {
+	clients.Delete(client)
+}
 
+// Closes connection opened with the session
+func removeSessionClient(userId int, sessionId string) {
+	clients.DeleteBySessionId(userId, sessionId)
 }
 
-func readMessages(id int) {
-	client := clients.Get(id)
-	if client == nil {
-		return
-	}
+// Closes connections of the user on every device
+func removeUserClients(userId int) {
+	clients.DeleteAll(userId)
+}
 
+func readMessages(client *Client) {
 	defer func() {
-		removeClient(id)
+		removeClient(client)
 	}()
 	for {
 		_, message, err := client.conn.ReadMessage()
 		fmt.Println("Incoming message: ", string(message))
 		if err != nil {
 			// Error:  websocket: close 1001 (going away)
-			fmt.Println(err, " Connection: ", id)
+			fmt.Println(err, " Connection: ", client.user.Id)
 			return
 		}
 	}
 }
 
-func writeMessage(id int) {
-	client := clients.Get(id)
-	if client == nil {
-		return
-	}
-
+func writeMessage(client *Client) {
 	defer func() {
-		removeClient(id)
+		removeClient(client)
 	}()
 	for {
 		select {
@@ -93,8 +102,20 @@ func writeMessage(id int) {
 }
 
 func notifyClient(id int, message []byte) {
-	client := clients.Get(id)
-	if client != nil {
+	for _, client := range clients.Get(id) {
 		client.messageChannel <- message
 	}
 }
+
+// Pushes a notification unless the recipient has muted the sender. The saved notification
+// is hidden from the muter's list by the notifications query
+func pushNotification(recipientId int, senderId int, message []byte) {
+	muted, err := db.IsMuted(recipientId, senderId)
+	if err != nil {
+		fmt.Println("Error: could not get mutes from database for user ", recipientId, ". ", err)
+		return
+	}
+	if !muted {
+		notifyClient(recipientId, message)
+	}
+}